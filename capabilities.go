@@ -0,0 +1,85 @@
+package proxyclient
+
+import "strings"
+
+// SchemeCapabilities describes what the proxies of a scheme can do.
+type SchemeCapabilities struct {
+	// Registered reports whether New and NewDialer can build clients for
+	// the scheme in this build.
+	Registered bool
+	// Package names the package that provides the scheme: "core" for this
+	// one, or "xray", "ss" and "hy2" for the subpackages.
+	Package string
+	// Network is the network the proxy server listens on, "tcp" or "udp".
+	Network string
+	// TCP and UDP report which traffic the proxy can relay.
+	TCP bool
+	UDP bool
+	// RemoteDNS reports whether target host names are resolved by the
+	// proxy rather than locally.
+	RemoteDNS bool
+	// Auth reports whether the proxy can require credentials.
+	Auth bool
+	// Multiplex reports whether the protocol can carry many connections
+	// over one connection to the proxy server.
+	Multiplex bool
+}
+
+// RegisterCapabilities records the capabilities of proto in
+// DefaultRegistry.
+func RegisterCapabilities(proto string, c SchemeCapabilities) {
+	DefaultRegistry.RegisterCapabilities(proto, c)
+}
+
+// Schemes returns every scheme DefaultRegistry can build a client for.
+func Schemes() []string {
+	return DefaultRegistry.Schemes()
+}
+
+// Capabilities returns the capabilities of scheme in DefaultRegistry.
+func Capabilities(scheme string) SchemeCapabilities {
+	return DefaultRegistry.Capabilities(scheme)
+}
+
+// RegisterCapabilities records the capabilities of proto. Registered is
+// ignored; it always reflects whether proto has a ProxyFunc or DialerFunc.
+func (r *Registry) RegisterCapabilities(proto string, c SchemeCapabilities) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.capabilities[strings.ToLower(proto)] = c
+}
+
+// Capabilities returns the capabilities of scheme. A scheme that was
+// registered without any is assumed to relay TCP only, over TCP.
+func (r *Registry) Capabilities(scheme string) SchemeCapabilities {
+	scheme = strings.ToLower(scheme)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.capabilities[scheme]
+	if !ok {
+		c = SchemeCapabilities{Network: "tcp", TCP: true}
+	}
+
+	_, hasProxy := r.proxies[scheme]
+	_, hasDialer := r.dialers[scheme]
+	c.Registered = hasProxy || hasDialer
+
+	return c
+}
+
+func init() {
+	RegisterCapabilities("http", SchemeCapabilities{Package: "core", Network: "tcp", TCP: true, RemoteDNS: true, Auth: true})
+	RegisterCapabilities("https", SchemeCapabilities{Package: "core", Network: "tcp", TCP: true, RemoteDNS: true, Auth: true})
+	RegisterCapabilities("socks5", SchemeCapabilities{Package: "core", Network: "tcp", TCP: true, UDP: true, RemoteDNS: true, Auth: true})
+	RegisterCapabilities("socks5h", SchemeCapabilities{Package: "core", Network: "tcp", TCP: true, UDP: true, RemoteDNS: true, Auth: true})
+	RegisterCapabilities("socks4", SchemeCapabilities{Package: "core", Network: "tcp", TCP: true})
+	RegisterCapabilities("socks4a", SchemeCapabilities{Package: "core", Network: "tcp", TCP: true, RemoteDNS: true})
+
+	// hysteria2 servers listen on UDP, which PingWithScheme needs to know
+	// whether or not the hy2 package is linked in to build clients for them.
+	hy2 := SchemeCapabilities{Package: "hy2", Network: "udp", TCP: true, UDP: true, RemoteDNS: true, Auth: true, Multiplex: true}
+	RegisterCapabilities("hysteria2", hy2)
+	RegisterCapabilities("hy2", hy2)
+}
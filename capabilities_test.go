@@ -0,0 +1,42 @@
+package proxyclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilities(t *testing.T) {
+	require.Subset(t, Schemes(), []string{"http", "https", "socks4", "socks4a", "socks5", "socks5h"})
+
+	socks5 := Capabilities("SOCKS5")
+	require.True(t, socks5.Registered)
+	require.Equal(t, "core", socks5.Package)
+	require.True(t, socks5.UDP)
+	require.True(t, socks5.RemoteDNS)
+
+	require.False(t, Capabilities("socks4").RemoteDNS)
+	require.True(t, Capabilities("socks4a").RemoteDNS)
+
+	require.False(t, Capabilities("nope").Registered)
+
+	hy2 := Capabilities("hysteria2")
+	require.False(t, hy2.Registered)
+	require.Equal(t, "udp", hy2.Network)
+	require.Equal(t, "hy2", Capabilities("hy2").Package)
+
+	r := NewRegistry()
+	r.RegisterDialer("custom", Socks5Dialer)
+	custom := r.Capabilities("custom")
+	require.True(t, custom.Registered)
+	require.Equal(t, "tcp", custom.Network)
+	require.True(t, custom.TCP)
+
+	r.RegisterCapabilities("custom", SchemeCapabilities{Package: "custom", Network: "udp", UDP: true})
+	custom = r.Capabilities("custom")
+	require.True(t, custom.Registered)
+	require.Equal(t, "udp", custom.Network)
+
+	r.Unregister("custom")
+	require.False(t, r.Capabilities("custom").Registered)
+}
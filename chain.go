@@ -0,0 +1,135 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// NewChain creates a client whose connections pass through every proxy in
+// proxyURLs, in order. The first hop is reached directly (or through
+// WithForward, if given) and every later hop is reached through the one
+// before it, so the last proxy is the one the target sees. CloseClient
+// releases every hop.
+func NewChain(proxyURLs []string, options ...Option) (*http.Client, error) {
+	if len(proxyURLs) == 0 {
+		return nil, ErrEmptyChain
+	}
+
+	last := len(proxyURLs) - 1
+	forward, hops, err := chainDialer(proxyURLs[:last], options)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := New(proxyURLs[last], withChainForward(options, forward)...)
+	if err != nil {
+		closeHops(hops) //nolint: errcheck
+		return nil, err
+	}
+
+	c.Transport = withClosers(c.Transport, hops...)
+	return c, nil
+}
+
+// NewChainDialer is the ContextDialer counterpart of NewChain. When a hop
+// holds resources of its own the dialer implements io.Closer, and Close
+// releases every hop. WithObserver and WithRateLimit see what passes the
+// last hop.
+func NewChainDialer(proxyURLs []string, options ...Option) (ContextDialer, error) {
+	if len(proxyURLs) == 0 {
+		return nil, ErrEmptyChain
+	}
+
+	d, hops, err := chainDialer(proxyURLs, options)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := &Options{}
+	for _, o := range options {
+		o(opt)
+	}
+	if u, err := url.Parse(proxyURLs[len(proxyURLs)-1]); err == nil {
+		opt.setProxy(u)
+	}
+	d = opt.instrument(d)
+
+	if len(hops) == 0 {
+		return d, nil
+	}
+	return &chain{ContextDialer: d, hops: hops}, nil
+}
+
+// chainDialer builds the hops one by one, feeding each dialer into the next
+// as its forward dialer. It returns the caller's own forward dialer (or nil)
+// for an empty list, along with the hops that need closing.
+func chainDialer(proxyURLs []string, options []Option) (ContextDialer, []io.Closer, error) {
+	opt := &Options{}
+	for _, o := range options {
+		o(opt)
+	}
+
+	var hops []io.Closer
+	forward := opt.Forward
+	for _, proxyURL := range proxyURLs {
+		hop := &Options{}
+		for _, o := range withChainForward(options, forward) {
+			o(hop)
+		}
+
+		d, err := newDialer(proxyURL, hop)
+		if err != nil {
+			closeHops(hops) //nolint: errcheck
+			return nil, nil, err
+		}
+		if c, ok := d.(io.Closer); ok {
+			hops = append(hops, c)
+		}
+		forward = d
+	}
+
+	return forward, hops, nil
+}
+
+func withChainForward(options []Option, forward ContextDialer) []Option {
+	if forward == nil {
+		return options
+	}
+
+	return append(options[:len(options):len(options)], WithForward(forward))
+}
+
+// closeHops closes hops, the last one first, since it runs over the others.
+func closeHops(hops []io.Closer) error {
+	var errs []error
+	for i := len(hops) - 1; i >= 0; i-- {
+		if err := hops[i].Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// chain is a chain dialer with hops to close.
+type chain struct {
+	ContextDialer
+	hops []io.Closer
+}
+
+// ListenPacket relays UDP through the last hop, if it can.
+func (c *chain) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	pd, ok := c.ContextDialer.(PacketDialer)
+	if !ok {
+		return nil, fmt.Errorf("chain: %w", ErrUDPUnsupported)
+	}
+	return pd.ListenPacket(ctx, network)
+}
+
+func (c *chain) Close() error {
+	return closeHops(c.hops)
+}
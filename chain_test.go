@@ -0,0 +1,108 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChain(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from target server") //nolint: errcheck
+	}))
+	defer targetServer.Close()
+
+	var socksUsed, httpUsed atomic.Bool
+
+	socksProxy := startSocksServer(t, func(conn net.Conn, t *testing.T) {
+		socksUsed.Store(true)
+		handleSocks5(conn, t)
+	})
+	defer socksProxy.Close() //nolint: errcheck
+
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpUsed.Store(true)
+		if r.Method == http.MethodConnect {
+			handleTunneling(w, r)
+		} else {
+			handleHTTP(w, r)
+		}
+	}))
+	defer httpProxy.Close()
+
+	chain := []string{
+		fmt.Sprintf("socks5://%s", socksProxy.Addr()),
+		httpProxy.URL,
+	}
+
+	t.Run("client", func(t *testing.T) {
+		socksUsed.Store(false)
+		httpUsed.Store(false)
+
+		client, err := NewChain(chain)
+		require.NoError(t, err)
+
+		resp, err := client.Get(targetServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint: errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello from target server", string(body))
+
+		require.True(t, socksUsed.Load(), "first hop was not used")
+		require.True(t, httpUsed.Load(), "second hop was not used")
+	})
+
+	t.Run("dialer", func(t *testing.T) {
+		socksUsed.Store(false)
+		httpUsed.Store(false)
+
+		echo := startEchoServer(t)
+		defer echo.Close() //nolint: errcheck
+
+		d, err := NewChainDialer(chain)
+		require.NoError(t, err)
+
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn)
+
+		require.True(t, socksUsed.Load(), "first hop was not used")
+		require.True(t, httpUsed.Load(), "second hop was not used")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := NewChain(nil)
+		require.ErrorIs(t, err, ErrEmptyChain)
+
+		_, err = NewChainDialer(nil)
+		require.ErrorIs(t, err, ErrEmptyChain)
+	})
+
+	t.Run("socks4 hop", func(t *testing.T) {
+		socksUsed.Store(false)
+
+		socks4Proxy := startSocksServer(t, handleSocks4)
+		defer socks4Proxy.Close() //nolint: errcheck
+
+		echo := startEchoServer(t)
+		defer echo.Close() //nolint: errcheck
+
+		d, err := NewChainDialer([]string{chain[0], "socks4://" + socks4Proxy.Addr().String()})
+		require.NoError(t, err)
+
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn)
+
+		require.True(t, socksUsed.Load(), "first hop was not used")
+	})
+}
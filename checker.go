@@ -0,0 +1,200 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCheckWorkers = 64
+	defaultCheckTimeout = 10 * time.Second
+)
+
+// CheckResult is the outcome of checking one proxy URL.
+type CheckResult struct {
+	URL string
+	ProbeResult
+}
+
+// Checker validates large numbers of proxy URLs concurrently. Every URL is
+// pinged first as a cheap pre-filter and, if it answers, probed end to
+// end. Whatever a scheme starts for a URL (xray instances, hysteria2
+// connections) is released as soon as its check is done, so memory stays
+// flat however many URLs pass through.
+type Checker struct {
+	workers      int
+	schemeLimits map[string]int
+	timeout      time.Duration
+	deadline     time.Duration
+	target       string
+	options      []Option
+	registry     *Registry
+}
+
+type CheckerOption func(*Checker)
+
+// WithWorkers sets how many URLs are checked at once. The default is 64.
+func WithWorkers(n int) CheckerOption {
+	return func(c *Checker) {
+		if n > 0 {
+			c.workers = n
+		}
+	}
+}
+
+// WithSchemeLimit caps how many URLs of scheme are checked at once, on top
+// of the worker limit. It is useful for schemes that are expensive to
+// start, such as the xray based ones.
+func WithSchemeLimit(scheme string, n int) CheckerOption {
+	return func(c *Checker) {
+		if n > 0 {
+			c.schemeLimits[strings.ToLower(scheme)] = n
+		}
+	}
+}
+
+// WithCheckTimeout bounds the check of a single URL. The default is 10s.
+func WithCheckTimeout(d time.Duration) CheckerOption {
+	return func(c *Checker) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithCheckDeadline bounds a whole Run. URLs that are still being checked
+// when it passes are reported as failed, and the rest are not read.
+func WithCheckDeadline(d time.Duration) CheckerOption {
+	return func(c *Checker) {
+		if d > 0 {
+			c.deadline = d
+		}
+	}
+}
+
+// WithCheckTarget sets the URL every proxy is probed with. See Probe.
+func WithCheckTarget(target string) CheckerOption {
+	return func(c *Checker) {
+		c.target = target
+	}
+}
+
+// WithCheckProxyOptions sets the options every proxy is probed with.
+func WithCheckProxyOptions(options ...Option) CheckerOption {
+	return func(c *Checker) {
+		c.options = options
+	}
+}
+
+// NewChecker creates a Checker.
+func NewChecker(options ...CheckerOption) *Checker {
+	c := &Checker{
+		workers:      defaultCheckWorkers,
+		schemeLimits: make(map[string]int),
+		timeout:      defaultCheckTimeout,
+	}
+
+	for _, o := range options {
+		o(c)
+	}
+
+	opt := &Options{}
+	for _, o := range c.options {
+		o(opt)
+	}
+	c.registry = opt.registry()
+
+	return c
+}
+
+// Run checks every URL received from urls and sends a result for each on
+// the returned channel, in the order the checks finish. The channel is
+// closed once urls is closed and drained, or when ctx is done or the
+// deadline passes; callers must keep reading until then.
+func (c *Checker) Run(ctx context.Context, urls <-chan string) <-chan CheckResult {
+	results := make(chan CheckResult, c.workers)
+
+	var cancel context.CancelFunc
+	if c.deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	limits := make(map[string]chan struct{}, len(c.schemeLimits))
+	for scheme, n := range c.schemeLimits {
+		limits[scheme] = make(chan struct{}, n)
+	}
+
+	var wg sync.WaitGroup
+	for range c.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				var proxyURL string
+				var ok bool
+				select {
+				case <-ctx.Done():
+					return
+				case proxyURL, ok = <-urls:
+					if !ok || ctx.Err() != nil {
+						return
+					}
+				}
+
+				sem := limits[SchemeOfURL(proxyURL)]
+				if sem != nil {
+					select {
+					case sem <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+				}
+
+				r := c.check(ctx, proxyURL)
+
+				if sem != nil {
+					<-sem
+				}
+
+				results <- r
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(results)
+	}()
+
+	return results
+}
+
+// check pings proxyURL and then probes it, releasing everything the probe
+// started.
+func (c *Checker) check(ctx context.Context, proxyURL string) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	r := CheckResult{URL: proxyURL}
+	start := time.Now()
+
+	if host, port, scheme := proxyEndpoint(c.registry, proxyURL); host != "" && port != "" {
+		if !PingWithScheme(host, port, scheme, c.timeout) {
+			r.Err = &ProbeError{Stage: ProbeConnect, Err: fmt.Errorf("%s is unreachable", proxyURL)}
+			r.Total = time.Since(start)
+			return r
+		}
+	}
+
+	r.Err = probe(ctx, proxyURL, c.target, c.options, &r.ProbeResult)
+	r.Total = time.Since(start)
+
+	return r
+}
@@ -0,0 +1,153 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// closingDialer counts how often it is closed, like the xray and hy2
+// dialers that own a running client.
+type closingDialer struct {
+	Dialer
+	closed *atomic.Int64
+}
+
+func (d *closingDialer) Close() error {
+	d.closed.Add(1)
+	return nil
+}
+
+func collect(results <-chan CheckResult) map[string]CheckResult {
+	m := make(map[string]CheckResult)
+	for r := range results {
+		m[r.URL] = r
+	}
+	return m
+}
+
+func feed(urls ...string) <-chan string {
+	ch := make(chan string, len(urls))
+	for _, u := range urls {
+		ch <- u
+	}
+	close(ch)
+	return ch
+}
+
+func TestChecker(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "203.0.113.7") //nolint: errcheck
+	}))
+	defer target.Close()
+
+	socks := startSocksServer(t, handleSocks5)
+	defer socks.Close() //nolint: errcheck
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := "socks5://" + ln.Addr().String()
+	ln.Close() //nolint: errcheck
+
+	t.Run("results", func(t *testing.T) {
+		var down atomic.Bool
+		httpProxy := startFlakyProxy(t, &down)
+		good := "socks5://" + socks.Addr().String()
+
+		c := NewChecker(WithWorkers(2), WithCheckTarget(target.URL), WithCheckTimeout(5*time.Second))
+		results := collect(c.Run(context.Background(), feed(good, httpProxy, dead)))
+		require.Len(t, results, 3)
+
+		require.True(t, results[good].OK())
+		require.Equal(t, "203.0.113.7", results[good].ExitIP)
+		require.True(t, results[httpProxy].OK())
+
+		var pe *ProbeError
+		require.ErrorAs(t, results[dead].Err, &pe)
+		require.Equal(t, ProbeConnect, pe.Stage)
+	})
+
+	t.Run("releases dialers", func(t *testing.T) {
+		var dials, closed atomic.Int64
+		reg := NewRegistry()
+		reg.RegisterDialer("closing", func(u *url.URL, o *Options) (ContextDialer, error) {
+			dials.Add(1)
+			d, err := Socks5Dialer(&url.URL{Scheme: "socks5", Host: u.Host}, o)
+			if err != nil {
+				return nil, err
+			}
+			return &closingDialer{Dialer: d.DialContext, closed: &closed}, nil
+		})
+
+		proxyURL := "closing://" + socks.Addr().String()
+		c := NewChecker(WithCheckTarget(target.URL), WithCheckProxyOptions(WithRegistry(reg)))
+		results := collect(c.Run(context.Background(), feed(proxyURL)))
+		require.True(t, results[proxyURL].OK())
+
+		require.Positive(t, dials.Load())
+		require.Equal(t, dials.Load(), closed.Load())
+	})
+
+	t.Run("scheme limit", func(t *testing.T) {
+		var active, peak atomic.Int64
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}))
+		defer slow.Close()
+
+		urls := make([]string, 6)
+		for i := range urls {
+			// distinct URLs for the same proxy
+			urls[i] = fmt.Sprintf("socks5://u%d@%s", i, socks.Addr())
+		}
+
+		c := NewChecker(WithWorkers(6), WithSchemeLimit("SOCKS5", 2), WithCheckTarget(slow.URL))
+		results := collect(c.Run(context.Background(), feed(urls...)))
+		require.Len(t, results, 6)
+		require.LessOrEqual(t, peak.Load(), int64(2))
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		hang := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer hang.Close()
+
+		urls := make(chan string)
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case urls <- fmt.Sprintf("socks5://u%d@%s", i, socks.Addr()):
+				case <-time.After(5 * time.Second):
+					return
+				}
+			}
+		}()
+
+		start := time.Now()
+		c := NewChecker(WithWorkers(2), WithCheckTarget(hang.URL), WithCheckDeadline(200*time.Millisecond))
+		results := collect(c.Run(context.Background(), urls))
+		require.Less(t, time.Since(start), 3*time.Second)
+
+		require.Len(t, results, 2)
+		for _, r := range results {
+			require.Error(t, r.Err)
+		}
+	})
+}
@@ -0,0 +1,514 @@
+package proxyclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnsupportedClashType  = errors.New("proxyclient: unsupported clash proxy type")
+	ErrUnsupportedClashField = errors.New("proxyclient: unsupported clash proxy fields")
+)
+
+// ClashParser converts a Clash proxy entry into a URL. Fields it does not
+// read are reported by ParseClash.
+type ClashParser func(e *ClashEntry) (URL, error)
+
+// ClashMarshaler converts a URL into a Clash proxy entry. Fields Clash
+// cannot represent are reported with UnsupportedClashFields.
+type ClashMarshaler func(u URL) (map[string]any, error)
+
+// RegisterClashParser registers the parser of a Clash proxy type, e.g.
+// "vmess" or "hysteria2", in DefaultRegistry.
+func RegisterClashParser(clashType string, f ClashParser) {
+	DefaultRegistry.RegisterClashParser(clashType, f)
+}
+
+// RegisterClashMarshaler registers the marshaler of a URL protocol in
+// DefaultRegistry.
+func RegisterClashMarshaler(proto string, f ClashMarshaler) {
+	DefaultRegistry.RegisterClashMarshaler(proto, f)
+}
+
+// RegisterClashParser registers the parser of a Clash proxy type, replacing
+// any previous one. Types and protocols are matched case-insensitively.
+func (r *Registry) RegisterClashParser(clashType string, f ClashParser) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clashParsers[strings.ToLower(clashType)] = f
+}
+
+// RegisterClashMarshaler registers the marshaler of a URL protocol,
+// replacing any previous one.
+func (r *Registry) RegisterClashMarshaler(proto string, f ClashMarshaler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clashMarshalers[strings.ToLower(proto)] = f
+}
+
+// LookupClashParser returns the parser of a Clash proxy type, if there is
+// one.
+func (r *Registry) LookupClashParser(clashType string) (ClashParser, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.clashParsers[strings.ToLower(clashType)]
+	return f, ok
+}
+
+// LookupClashMarshaler returns the marshaler of a URL protocol, if there is
+// one.
+func (r *Registry) LookupClashMarshaler(proto string) (ClashMarshaler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.clashMarshalers[strings.ToLower(proto)]
+	return f, ok
+}
+
+func init() {
+	RegisterClashParser("socks5", parseSocksClash)
+	RegisterClashParser("http", parseHTTPClash)
+
+	RegisterClashMarshaler("socks5", marshalStdClash)
+	RegisterClashMarshaler("socks5h", marshalStdClash)
+	RegisterClashMarshaler("http", marshalStdClash)
+	RegisterClashMarshaler("https", marshalStdClash)
+}
+
+// ClashError reports a Clash proxy entry that could not be converted, or
+// that was converted without some of its fields. Index is the 0-based
+// position of the entry in the proxies list.
+type ClashError struct {
+	Index int
+	Name  string
+	Type  string
+	Err   error
+}
+
+func (e *ClashError) Error() string {
+	return fmt.Sprintf("clash proxy %d %q (%s): %v", e.Index, e.Name, e.Type, e.Err)
+}
+
+func (e *ClashError) Unwrap() error {
+	return e.Err
+}
+
+// ParseClash reads a Clash / Clash.Meta config and converts every entry of
+// its `proxies:` list with the parser registered in DefaultRegistry for the
+// entry's type.
+//
+// A bad entry does not abort the config. Entries that cannot be converted
+// are skipped; entries with fields the URL type cannot represent are kept,
+// and a ClashError wrapping ErrUnsupportedClashField lists those fields.
+func ParseClash(r io.Reader) ([]URL, []error) {
+	return DefaultRegistry.ParseClash(r)
+}
+
+// ParseClash is ParseClash with the Clash parsers of reg.
+func (reg *Registry) ParseClash(r io.Reader) ([]URL, []error) {
+	var doc struct {
+		Proxies []map[string]any `yaml:"proxies"`
+	}
+
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, []error{fmt.Errorf("proxyclient: invalid clash config: %w", err)}
+	}
+
+	var (
+		items []URL
+		errs  []error
+	)
+
+	for i, p := range doc.Proxies {
+		u, err := reg.ParseClashProxy(p)
+		if err != nil {
+			e := &ClashError{Index: i, Err: err}
+			e.Name, _ = p["name"].(string)
+			e.Type, _ = p["type"].(string)
+			errs = append(errs, e)
+
+			if !errors.Is(err, ErrUnsupportedClashField) {
+				continue
+			}
+		}
+		items = append(items, u)
+	}
+
+	return items, errs
+}
+
+// ParseClashProxy converts a single Clash proxy entry. If the entry has
+// fields the URL type cannot represent, both the URL and an error wrapping
+// ErrUnsupportedClashField are returned.
+//
+// The udp field follows from the scheme: it is understood for schemes whose
+// capabilities include UDP and set on the entries MarshalClashProxy returns
+// for them.
+func ParseClashProxy(p map[string]any) (URL, error) {
+	return DefaultRegistry.ParseClashProxy(p)
+}
+
+// ParseClashProxy is ParseClashProxy with the Clash parsers of r.
+func (r *Registry) ParseClashProxy(p map[string]any) (URL, error) {
+	e := newClashEntry(p)
+
+	typ := e.String("type")
+	e.String("name")
+
+	parser, ok := r.LookupClashParser(typ)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedClashType, typ)
+	}
+
+	if !e.Has("server") || !e.Has("port") {
+		return nil, errors.New("proxyclient: clash proxy needs a server and a port")
+	}
+
+	u, err := parser(e)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whether UDP can be relayed follows from the scheme, so udp is
+	// understood for every scheme that can.
+	if r.Capabilities(u.Protocol()).UDP {
+		e.Bool("udp")
+	}
+
+	return u, UnsupportedClashFields(e.unused()...)
+}
+
+// UnsupportedClashFields returns an error wrapping ErrUnsupportedClashField
+// that lists fields, or nil if there are none.
+func UnsupportedClashFields(fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedClashField, strings.Join(fields, ", "))
+}
+
+// MarshalClash converts urls into a Clash config with a `proxies:` list.
+// Like ParseClash, it reports problems per URL: URLs that cannot be
+// converted are left out, URLs with fields Clash cannot represent are kept
+// without them.
+func MarshalClash(urls []URL) ([]byte, []error) {
+	return DefaultRegistry.MarshalClash(urls)
+}
+
+// MarshalClash is MarshalClash with the Clash marshalers of r.
+func (r *Registry) MarshalClash(urls []URL) ([]byte, []error) {
+	var errs []error
+
+	proxies := &yaml.Node{Kind: yaml.SequenceNode}
+	for i, u := range urls {
+		p, err := r.MarshalClashProxy(u)
+		if err != nil {
+			errs = append(errs, &ClashError{Index: i, Name: u.Name(), Type: u.Protocol(), Err: err})
+			if p == nil {
+				continue
+			}
+		}
+
+		node, err := clashNode(p)
+		if err != nil {
+			errs = append(errs, &ClashError{Index: i, Name: u.Name(), Type: u.Protocol(), Err: err})
+			continue
+		}
+		proxies.Content = append(proxies.Content, node)
+	}
+
+	doc := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "proxies"},
+			proxies,
+		},
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, append(errs, err)
+	}
+	enc.Close() //nolint: errcheck
+
+	return buf.Bytes(), errs
+}
+
+// MarshalClashProxy converts u into a single Clash proxy entry. If u has
+// fields Clash cannot represent, both the entry and an error wrapping
+// ErrUnsupportedClashField are returned.
+func MarshalClashProxy(u URL) (map[string]any, error) {
+	return DefaultRegistry.MarshalClashProxy(u)
+}
+
+// MarshalClashProxy is MarshalClashProxy with the Clash marshalers of r.
+func (r *Registry) MarshalClashProxy(u URL) (map[string]any, error) {
+	marshaler, ok := r.LookupClashMarshaler(u.Protocol())
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedClashType, u.Protocol())
+	}
+
+	p, err := marshaler(u)
+	if err != nil && !errors.Is(err, ErrUnsupportedClashField) {
+		return nil, err
+	}
+
+	// Clash refers to proxies by name, so every entry needs one.
+	if name, _ := p["name"].(string); name == "" {
+		p["name"] = net.JoinHostPort(u.Host(), u.Port())
+	}
+	if r.Capabilities(u.Protocol()).UDP {
+		p["udp"] = true
+	}
+
+	return p, err
+}
+
+// clashKeyOrder lists the keys written first, in the order Clash configs
+// conventionally use; the rest follow alphabetically.
+var clashKeyOrder = []string{"name", "type", "server", "port"}
+
+func clashNode(p map[string]any) (*yaml.Node, error) {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+
+	rank := func(k string) int {
+		for i, o := range clashKeyOrder {
+			if k == o {
+				return i
+			}
+		}
+		return len(clashKeyOrder)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank(keys[i]), rank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, k := range keys {
+		v := &yaml.Node{}
+		if err := v.Encode(p[k]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: k}, v)
+	}
+
+	return node, nil
+}
+
+// ClashEntry gives ClashParsers typed access to a Clash proxy entry. It
+// remembers which fields were read, so that the fields a parser does not
+// understand can be reported instead of silently dropped.
+type ClashEntry struct {
+	prefix string
+	values map[string]any
+	used   map[string]bool
+}
+
+func newClashEntry(values map[string]any) *ClashEntry {
+	return &ClashEntry{
+		values: values,
+		used:   make(map[string]bool),
+	}
+}
+
+func (e *ClashEntry) get(key string) (any, bool) {
+	v, ok := e.values[key]
+	if ok {
+		e.used[e.prefix+key] = true
+	}
+	return v, ok && v != nil
+}
+
+// Has reports whether key is set, without marking it as read.
+func (e *ClashEntry) Has(key string) bool {
+	v, ok := e.values[key]
+	return ok && v != nil
+}
+
+// String returns key as a string. Scalars of other types are formatted.
+func (e *ClashEntry) String(key string) string {
+	v, ok := e.get(key)
+	if !ok {
+		return ""
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any, map[string]any:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Int returns key as an int, accepting numeric strings.
+func (e *ClashEntry) Int(key string) int {
+	v, ok := e.get(key)
+	if !ok {
+		return 0
+	}
+
+	switch v := v.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(v))
+		return n
+	default:
+		return 0
+	}
+}
+
+// Bool returns key as a bool, accepting "true" and "1" strings.
+func (e *ClashEntry) Bool(key string) bool {
+	v, ok := e.get(key)
+	if !ok {
+		return false
+	}
+
+	switch v := v.(type) {
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case string:
+		return strings.EqualFold(v, "true") || v == "1"
+	default:
+		return false
+	}
+}
+
+// Strings returns key as a list. A single string is split on commas.
+func (e *ClashEntry) Strings(key string) []string {
+	v, ok := e.get(key)
+	if !ok {
+		return nil
+	}
+
+	switch v := v.(type) {
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, it := range v {
+			items = append(items, fmt.Sprint(it))
+		}
+		return items
+	case string:
+		if v == "" {
+			return nil
+		}
+		return strings.Split(v, ",")
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// Entry returns the nested mapping at key, e.g. "ws-opts". It never returns
+// nil; a missing mapping reads as empty.
+func (e *ClashEntry) Entry(key string) *ClashEntry {
+	v, _ := e.get(key)
+	m, _ := v.(map[string]any)
+	return &ClashEntry{
+		prefix: e.prefix + key + ".",
+		values: m,
+		used:   e.used,
+	}
+}
+
+// unused returns the dotted paths of the fields that were never read.
+func (e *ClashEntry) unused() []string {
+	var items []string
+	for k, v := range e.values {
+		path := e.prefix + k
+		if m, ok := v.(map[string]any); ok && e.used[path] {
+			items = append(items, (&ClashEntry{prefix: path + ".", values: m, used: e.used}).unused()...)
+			continue
+		}
+		if !e.used[path] {
+			items = append(items, path)
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+func parseSocksClash(e *ClashEntry) (URL, error) {
+	return parseStdClash(e, "socks5")
+}
+
+func parseHTTPClash(e *ClashEntry) (URL, error) {
+	scheme := "http"
+	if e.Bool("tls") {
+		scheme = "https"
+	}
+	return parseStdClash(e, scheme)
+}
+
+func parseStdClash(e *ClashEntry, scheme string) (URL, error) {
+	u := &url.URL{
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(e.String("server"), strconv.Itoa(e.Int("port"))),
+		Fragment: e.String("name"),
+	}
+
+	if user, passwd := e.String("username"), e.String("password"); user != "" || passwd != "" {
+		u.User = url.UserPassword(user, passwd)
+	}
+
+	if !IsHost(u.Hostname()) {
+		return nil, ErrInvalidHost
+	}
+
+	return &stdURL{*u}, nil
+}
+
+func marshalStdClash(u URL) (map[string]any, error) {
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %w", err)
+	}
+
+	p := map[string]any{
+		"name":   u.Name(),
+		"server": u.Host(),
+		"port":   port,
+	}
+
+	switch u.Protocol() {
+	case "socks5", "socks5h":
+		p["type"] = "socks5"
+	case "https":
+		p["type"] = "http"
+		p["tls"] = true
+	default:
+		p["type"] = "http"
+	}
+
+	if u.User() != "" || u.Password() != "" {
+		p["username"] = u.User()
+		p["password"] = u.Password()
+	}
+
+	return p, nil
+}
@@ -4,12 +4,19 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
-	"strings"
 )
 
 var (
 	ErrUnknownProtocol = errors.New("proxyclient: unknown proxy protocol")
 	ErrInvalidHost     = errors.New("proxyclient: invalid proxy host")
+	ErrEmptyChain      = errors.New("proxyclient: empty proxy chain")
+	ErrEmptyPool       = errors.New("proxyclient: empty proxy pool")
+	// ErrChainUnsupported is returned when a scheme cannot reach its server
+	// through a forward dialer and so cannot be used behind another hop.
+	ErrChainUnsupported = errors.New("proxyclient: proxy cannot be chained")
+	// ErrUDPUnsupported is returned by ListenPacket for schemes that can
+	// only carry TCP.
+	ErrUDPUnsupported = errors.New("proxyclient: proxy cannot relay UDP")
 )
 
 func New(proxyURL string, options ...Option) (*http.Client, error) {
@@ -33,10 +40,11 @@ func New(proxyURL string, options ...Option) (*http.Client, error) {
 		return nil, err
 	}
 
-	f, ok := supportProxies[strings.ToLower(u.Scheme)]
-	if !ok {
-		return nil, ErrUnknownProtocol
+	f, err := opt.registry().LookupProxy(u.Scheme)
+	if err != nil {
+		return nil, err
 	}
+	opt.setProxy(u)
 
 	c.Transport, err = f(u, opt)
 	if err != nil {
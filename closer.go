@@ -0,0 +1,114 @@
+package proxyclient
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Transport is the http.Transport of a proxy whose protocol holds resources
+// beyond its connections, such as a QUIC connection, an SSH session or an
+// xray instance. Close releases them; CloseIdleConnections only drops the
+// idle connections, as it does for any http.Transport.
+type Transport struct {
+	*http.Transport
+
+	closers   []io.Closer
+	closeOnce sync.Once
+	closeErr  error
+
+	// roundTrip, when set, is used instead of the RoundTrip of the
+	// embedded transport.
+	roundTrip func(*http.Request) (*http.Response, error)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.roundTrip != nil {
+		return t.roundTrip(req)
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// ClosableTransport returns tr, made closable when d holds resources of its
+// own, i.e. implements io.Closer. ProxyFuncs that build their transport
+// from a dialer return it, so that CloseClient can release the dialer.
+func ClosableTransport(tr *http.Transport, d ContextDialer) http.RoundTripper {
+	if c, ok := d.(io.Closer); ok {
+		return withClosers(tr, c)
+	}
+	return tr
+}
+
+// withClosers makes rt close closers, which it depends on, after its own
+// resources when it is closed. Round trippers other than *http.Transport
+// and *Transport are returned unchanged.
+func withClosers(rt http.RoundTripper, closers ...io.Closer) http.RoundTripper {
+	if len(closers) == 0 {
+		return rt
+	}
+
+	switch tr := rt.(type) {
+	case *Transport:
+		tr.closers = append(closers[:len(closers):len(closers)], tr.closers...)
+		return tr
+	case *http.Transport:
+		return &Transport{Transport: tr, closers: closers}
+	default:
+		return rt
+	}
+}
+
+// Close closes the idle connections and then releases the protocol
+// resources, last created first. Later calls return the same error.
+func (t *Transport) Close() error {
+	t.closeOnce.Do(func() {
+		t.Transport.CloseIdleConnections()
+		t.closeErr = closeHops(t.closers)
+	})
+	return t.closeErr
+}
+
+// CloseClient releases everything New, NewChain or a Pool or Failover
+// transport allocated for c: its idle connections and, for protocols that
+// keep state such as hysteria2, ssh and the xray schemes, the connection
+// or instance behind them. c must not be used afterwards.
+func CloseClient(c *http.Client) error {
+	if c == nil || c.Transport == nil {
+		return nil
+	}
+
+	if closer, ok := c.Transport.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	c.CloseIdleConnections()
+	return nil
+}
+
+// closeDialer closes d if it holds resources of its own.
+func closeDialer(d ContextDialer) error {
+	if c, ok := d.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// closerFunc is an io.Closer calling itself.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// closeAll closes every closable item of rts; it is used to release the
+// transports built so far when a constructor fails half way.
+func closeAll(rts []http.RoundTripper) error {
+	var errs []error
+	for _, rt := range rts {
+		if c, ok := rt.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
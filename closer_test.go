@@ -0,0 +1,164 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// hopDialer dials directly and records when it is closed.
+type hopDialer struct {
+	net.Dialer
+	host string
+	log  *closeLog
+}
+
+func (d *hopDialer) Close() error {
+	d.log.add(d.host)
+	return nil
+}
+
+type closeLog struct {
+	mu     sync.Mutex
+	closed []string
+}
+
+func (l *closeLog) add(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = append(l.closed, host)
+}
+
+func (l *closeLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.closed...)
+}
+
+func TestCloseClient(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok") //nolint: errcheck
+	}))
+	defer target.Close()
+
+	newRegistry := func() (*Registry, *closeLog) {
+		log := &closeLog{}
+		r := DefaultRegistry.Clone()
+		r.RegisterDialer("closing", func(u *url.URL, o *Options) (ContextDialer, error) {
+			return &hopDialer{host: u.Host, log: log}, nil
+		})
+		return r, log
+	}
+
+	get := func(t *testing.T, c *http.Client) {
+		resp, err := c.Get(target.URL)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body) //nolint: errcheck
+		resp.Body.Close()              //nolint: errcheck
+	}
+
+	t.Run("new", func(t *testing.T) {
+		r, log := newRegistry()
+
+		c, err := New("closing://a:1", WithRegistry(r))
+		require.NoError(t, err)
+		get(t, c)
+
+		require.NoError(t, CloseClient(c))
+		require.NoError(t, CloseClient(c))
+		require.Equal(t, []string{"a:1"}, log.get())
+	})
+
+	t.Run("plain transport", func(t *testing.T) {
+		c, err := New("socks5://127.0.0.1:1")
+		require.NoError(t, err)
+		require.IsType(t, &http.Transport{}, c.Transport)
+		require.NoError(t, CloseClient(c))
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		r, log := newRegistry()
+
+		c, err := NewChain([]string{"closing://a:1", "closing://b:1", "closing://c:1"}, WithRegistry(r))
+		require.NoError(t, err)
+		get(t, c)
+
+		require.NoError(t, CloseClient(c))
+		require.Equal(t, []string{"c:1", "b:1", "a:1"}, log.get())
+
+		d, err := NewChainDialer([]string{"closing://a:1", "closing://b:1"}, WithRegistry(r))
+		require.NoError(t, err)
+		require.NoError(t, d.(io.Closer).Close())
+		require.Equal(t, []string{"c:1", "b:1", "a:1", "b:1", "a:1"}, log.get())
+	})
+
+	t.Run("chain failed", func(t *testing.T) {
+		r, log := newRegistry()
+
+		_, err := NewChain([]string{"closing://a:1", "unknown://b:1", "closing://c:1"}, WithRegistry(r))
+		require.ErrorIs(t, err, ErrUnknownProtocol)
+		require.Equal(t, []string{"a:1"}, log.get())
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		r, log := newRegistry()
+
+		p, err := NewPool([]string{"closing://a:1", "socks5://127.0.0.1:1", "closing://b:1"}, nil, WithRegistry(r))
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+		require.ElementsMatch(t, []string{"a:1", "b:1"}, log.get())
+
+		_, err = NewPool([]string{"closing://c:1", "unknown://d:1"}, nil, WithRegistry(r))
+		require.ErrorIs(t, err, ErrUnknownProtocol)
+		require.Contains(t, log.get(), "c:1")
+	})
+
+	t.Run("serve", func(t *testing.T) {
+		r, log := newRegistry()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.NoError(t, Serve(ctx, "127.0.0.1:0", "closing://a:1", WithRegistry(r)))
+		require.Equal(t, []string{"a:1"}, log.get())
+	})
+
+	t.Run("listen packet", func(t *testing.T) {
+		r, log := newRegistry()
+
+		_, err := ListenPacket(context.Background(), "closing://a:1", WithRegistry(r))
+		require.ErrorIs(t, err, ErrUDPUnsupported)
+		require.Equal(t, []string{"a:1"}, log.get())
+	})
+
+	t.Run("resolver", func(t *testing.T) {
+		r, log := newRegistry()
+
+		_, closer, err := NewResolver("closing://a:1", "tcp://127.0.0.1:53", WithRegistry(r))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+		require.Equal(t, []string{"a:1"}, log.get())
+
+		_, closer, err = NewResolver("closing://b:1", "https://127.0.0.1/dns-query", WithRegistry(r))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+		require.Equal(t, []string{"a:1", "b:1"}, log.get())
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		r, log := newRegistry()
+
+		f, err := NewFailover([]string{"closing://a:1", "closing://b:1"}, WithProxyOptions(WithRegistry(r)))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.NoError(t, f.Close())
+		require.ElementsMatch(t, []string{"a:1", "b:1"}, log.get())
+	})
+}
@@ -0,0 +1,189 @@
+package proxyclient
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ContextDialer dials a network address through a proxy. It has the same
+// method set as golang.org/x/net/proxy.ContextDialer, so values returned by
+// NewDialer can be handed to anything expecting that interface.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// PacketDialer is implemented by dialers that can also relay UDP. The
+// returned PacketConn sends each datagram to the address given to WriteTo.
+type PacketDialer interface {
+	ListenPacket(ctx context.Context, network string) (net.PacketConn, error)
+}
+
+// BindDialer is implemented by dialers that can have the proxy accept a
+// connection on the caller's behalf, as SOCKS5 BIND does. addr is the peer
+// expected to connect; the returned listener's Addr is where it should
+// connect to, and Accept yields its connection.
+type BindDialer interface {
+	Bind(ctx context.Context, addr string) (net.Listener, error)
+}
+
+// DialerFunc builds a ContextDialer for a proxy URL.
+type DialerFunc func(*url.URL, *Options) (ContextDialer, error)
+
+// RegisterDialer registers the DialerFunc of proto in DefaultRegistry.
+func RegisterDialer(proto string, f DialerFunc) {
+	DefaultRegistry.RegisterDialer(proto, f)
+}
+
+// Dialer adapts an ordinary dial function to ContextDialer, in the same way
+// http.HandlerFunc adapts a function to http.Handler.
+type Dialer func(ctx context.Context, network string, address string) (net.Conn, error)
+
+func (d Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// Dial implements golang.org/x/net/proxy.Dialer.
+func (d Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+// ListenPacketFunc adapts an ordinary function to PacketDialer.
+type ListenPacketFunc func(ctx context.Context, network string) (net.PacketConn, error)
+
+func (f ListenPacketFunc) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	return f(ctx, network)
+}
+
+// UDPDialer is a Dialer for schemes that carry both TCP and UDP.
+type UDPDialer struct {
+	Dialer
+	ListenPacketFunc
+}
+
+// NewDialer returns a dialer that tunnels raw connections through proxyURL.
+// Unlike New it is not tied to HTTP, so it can carry any TCP protocol.
+// WithRateLimit throttles its connections, UDP included, and WithObserver
+// sees its TCP connections.
+func NewDialer(proxyURL string, options ...Option) (ContextDialer, error) {
+	opt := &Options{}
+	for _, o := range options {
+		o(opt)
+	}
+
+	d, err := newDialer(proxyURL, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return opt.instrument(d), nil
+}
+
+// newDialer is NewDialer without the observer and the rate limit, for the
+// hops of a chain, whose traffic is observed and throttled at the last hop.
+func newDialer(proxyURL string, opt *Options) (ContextDialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := opt.registry().LookupDialer(u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	opt.setProxy(u)
+
+	return f(u, opt)
+}
+
+// wrapDialer returns a dialer that dials with dial and, when listen is not
+// nil, relays UDP through listen(d). It implements whichever of
+// PacketDialer, BindDialer and io.Closer d does, so that wrapping a dialer
+// does not hide what it can do.
+func wrapDialer(d ContextDialer, dial Dialer, listen func(PacketDialer) PacketDialer) ContextDialer {
+	pd, isPacket := d.(PacketDialer)
+	if isPacket && listen != nil {
+		pd = listen(pd)
+	}
+	bd, isBind := d.(BindDialer)
+	c, isCloser := d.(io.Closer)
+
+	switch {
+	case isPacket && isBind && isCloser:
+		return struct {
+			Dialer
+			PacketDialer
+			BindDialer
+			io.Closer
+		}{dial, pd, bd, c}
+	case isPacket && isBind:
+		return struct {
+			Dialer
+			PacketDialer
+			BindDialer
+		}{dial, pd, bd}
+	case isPacket && isCloser:
+		return struct {
+			Dialer
+			PacketDialer
+			io.Closer
+		}{dial, pd, c}
+	case isBind && isCloser:
+		return struct {
+			Dialer
+			BindDialer
+			io.Closer
+		}{dial, bd, c}
+	case isPacket:
+		return struct {
+			Dialer
+			PacketDialer
+		}{dial, pd}
+	case isBind:
+		return struct {
+			Dialer
+			BindDialer
+		}{dial, bd}
+	case isCloser:
+		return struct {
+			Dialer
+			io.Closer
+		}{dial, c}
+	default:
+		return dial
+	}
+}
+
+// CreateDialerTransport creates a transport whose connections are all
+// dialed through d, observed and throttled as o says.
+func CreateDialerTransport(d ContextDialer, o *Options) *http.Transport {
+	d = o.instrument(d)
+
+	tr := CreateTransport(o)
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return SetDeadline(conn, o.Timeout, tr.DisableKeepAlives)
+	}
+	tr.Proxy = nil
+
+	return tr
+}
+
+// dialerProxy turns a DialerFunc into a ProxyFunc, so schemes that only
+// register a dialer still work with New.
+func dialerProxy(f DialerFunc) ProxyFunc {
+	return func(u *url.URL, o *Options) (http.RoundTripper, error) {
+		d, err := f(u, o)
+		if err != nil {
+			return nil, err
+		}
+
+		return ClosableTransport(CreateDialerTransport(d, o), d), nil
+	}
+}
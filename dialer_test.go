@@ -0,0 +1,162 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer starts a raw TCP server that echoes everything it reads.
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()  //nolint: errcheck
+				io.Copy(conn, conn) // nolint: errcheck
+			}()
+		}
+	}()
+
+	return listener
+}
+
+// startSocksServer starts a test SOCKS server using handler.
+func startSocksServer(t *testing.T, handler func(net.Conn, *testing.T)) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handler(conn, t)
+		}
+	}()
+
+	return listener
+}
+
+func requireEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close() //nolint: errcheck
+
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+}
+
+func TestNewDialer(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close() //nolint: errcheck
+
+	t.Run("socks4", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks4)
+		defer proxy.Close() //nolint: errcheck
+
+		d, err := NewDialer(fmt.Sprintf("socks4://%s", proxy.Addr()))
+		require.NoError(t, err)
+
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn)
+	})
+
+	t.Run("socks5", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks5)
+		defer proxy.Close() //nolint: errcheck
+
+		d, err := NewDialer(fmt.Sprintf("socks5://%s", proxy.Addr()))
+		require.NoError(t, err)
+
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		var authorization string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Proxy-Authorization")
+			handleTunneling(w, r)
+		}))
+		defer proxy.Close()
+
+		u, err := url.Parse(proxy.URL)
+		require.NoError(t, err)
+		u.User = url.UserPassword("user", "pass")
+
+		d, err := NewDialer(u.String())
+		require.NoError(t, err)
+
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn)
+
+		require.Equal(t, "Basic dXNlcjpwYXNz", authorization)
+	})
+
+	t.Run("http rejected", func(t *testing.T) {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "denied", http.StatusProxyAuthRequired)
+		}))
+		defer proxy.Close()
+
+		d, err := NewDialer(proxy.URL)
+		require.NoError(t, err)
+
+		_, err = d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.ErrorContains(t, err, "407")
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewDialer("unknown://127.0.0.1:1")
+		require.ErrorIs(t, err, ErrUnknownProtocol)
+	})
+}
+
+func TestNewWithDialerOnlyScheme(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from target server") //nolint: errcheck
+	}))
+	defer targetServer.Close()
+
+	var dialed bool
+	reg := NewRegistry()
+	reg.RegisterDialer("direct+test", func(u *url.URL, o *Options) (ContextDialer, error) {
+		return Dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = true
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}), nil
+	})
+
+	client, err := New("direct+test://127.0.0.1:1", WithRegistry(reg))
+	require.NoError(t, err)
+
+	resp, err := client.Get(targetServer.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint: errcheck
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Hello from target server", string(body))
+	require.True(t, dialed, "registered dialer was not used")
+}
@@ -0,0 +1,83 @@
+package proxyclient
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors below tell why a proxy failed, so callers can decide whether
+// to retry, drop the proxy or fix its configuration. Dialers wrap them
+// around the underlying error; test for them with errors.Is or Classify.
+var (
+	// ErrProxyUnreachable means the proxy server could not be reached at
+	// all: its address did not resolve or the connection was refused or
+	// timed out.
+	ErrProxyUnreachable = errors.New("proxyclient: proxy unreachable")
+	// ErrProxyAuthFailed means the proxy server rejected the credentials.
+	ErrProxyAuthFailed = errors.New("proxyclient: proxy authentication failed")
+	// ErrHandshakeFailed means the proxy server was reached but did not
+	// speak the expected protocol, or broke off the handshake.
+	ErrHandshakeFailed = errors.New("proxyclient: proxy handshake failed")
+	// ErrTargetUnreachable means the proxy works but could not reach the
+	// target on the caller's behalf.
+	ErrTargetUnreachable = errors.New("proxyclient: target unreachable through proxy")
+	// ErrConfigUnsupported means the proxy URL is invalid or asks for
+	// something this package cannot do.
+	ErrConfigUnsupported = errors.New("proxyclient: unsupported proxy configuration")
+	// ErrDialPanic means the protocol implementation panicked while
+	// dialing. See WithRecover.
+	ErrDialPanic = errors.New("proxyclient: dial panic")
+)
+
+var errorKinds = []error{
+	ErrProxyUnreachable,
+	ErrProxyAuthFailed,
+	ErrHandshakeFailed,
+	ErrTargetUnreachable,
+	ErrConfigUnsupported,
+	ErrDialPanic,
+}
+
+// Classify returns which of ErrProxyUnreachable, ErrProxyAuthFailed,
+// ErrHandshakeFailed, ErrTargetUnreachable, ErrConfigUnsupported and
+// ErrDialPanic err stands for, or nil if it is none of them. When a chain
+// wraps several, as a proxy reached through another one may, the outermost
+// wins. ErrUnknownProtocol, ErrInvalidHost, ErrChainUnsupported and
+// ErrUDPUnsupported count as ErrConfigUnsupported.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, kind := range errorKinds {
+		if err == kind {
+			return kind
+		}
+	}
+
+	switch err {
+	case ErrUnknownProtocol, ErrInvalidHost, ErrChainUnsupported, ErrUDPUnsupported:
+		return ErrConfigUnsupported
+	}
+
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		return Classify(u.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, e := range u.Unwrap() {
+			if kind := Classify(e); kind != nil {
+				return kind
+			}
+		}
+	}
+
+	return nil
+}
+
+// classifyAs wraps err with kind unless it is classified already.
+func classifyAs(kind, err error) error {
+	if Classify(err) != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
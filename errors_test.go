@@ -0,0 +1,128 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"nil", nil, nil},
+		{"plain", errors.New("boom"), nil},
+		{"wrapped", fmt.Errorf("dial: %w", ErrProxyAuthFailed), ErrProxyAuthFailed},
+		{"joined", fmt.Errorf("%w: %w", ErrHandshakeFailed, io.EOF), ErrHandshakeFailed},
+		{"outermost wins", fmt.Errorf("%w: %w", ErrProxyUnreachable, fmt.Errorf("%w: boom", ErrTargetUnreachable)), ErrProxyUnreachable},
+		{"chain unsupported", fmt.Errorf("hop: %w", ErrChainUnsupported), ErrConfigUnsupported},
+		{"unknown protocol", ErrUnknownProtocol, ErrConfigUnsupported},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.want, Classify(test.err))
+		})
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := ln.Addr().String()
+	ln.Close() //nolint: errcheck
+
+	authRequired := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	defer authRequired.Close()
+
+	var down atomic.Bool
+	httpProxy := startFlakyProxy(t, &down)
+
+	dial := func(proxyURL, addr string) error {
+		d, err := NewDialer(proxyURL)
+		require.NoError(t, err)
+
+		conn, err := d.DialContext(context.Background(), "tcp", addr)
+		if err == nil {
+			conn.Close() //nolint: errcheck
+		}
+		return err
+	}
+
+	get := func(proxyURL, target string) error {
+		c, err := New(proxyURL)
+		require.NoError(t, err)
+
+		resp, err := c.Get(target)
+		if err == nil {
+			resp.Body.Close() //nolint: errcheck
+		}
+		return err
+	}
+
+	t.Run("proxy unreachable", func(t *testing.T) {
+		for _, scheme := range []string{"socks5", "socks4", "http"} {
+			err := dial(scheme+"://"+dead, "127.0.0.1:80")
+			require.ErrorIs(t, err, ErrProxyUnreachable, scheme)
+		}
+
+		require.ErrorIs(t, get("http://"+dead, "http://127.0.0.1:1"), ErrProxyUnreachable)
+	})
+
+	t.Run("socks5 auth failed", func(t *testing.T) {
+		proxy := startSocksServer(t, func(conn net.Conn, t *testing.T) {
+			defer conn.Close() //nolint: errcheck
+			buf := make([]byte, 3)
+			io.ReadFull(conn, buf)      //nolint: errcheck
+			conn.Write([]byte{5, 0xFF}) //nolint: errcheck
+			io.Copy(io.Discard, conn)   //nolint: errcheck
+		})
+		defer proxy.Close() //nolint: errcheck
+
+		err := dial("socks5://"+proxy.Addr().String(), "127.0.0.1:80")
+		require.ErrorIs(t, err, ErrProxyAuthFailed)
+	})
+
+	t.Run("http auth failed", func(t *testing.T) {
+		require.ErrorIs(t, dial(authRequired.URL, "127.0.0.1:443"), ErrProxyAuthFailed)
+		require.ErrorIs(t, get(authRequired.URL, "https://127.0.0.1:1"), ErrProxyAuthFailed)
+	})
+
+	t.Run("target unreachable", func(t *testing.T) {
+		require.ErrorIs(t, dial(httpProxy, dead), ErrTargetUnreachable)
+		require.ErrorIs(t, get(httpProxy, "https://"+dead), ErrTargetUnreachable)
+	})
+
+	t.Run("handshake failed", func(t *testing.T) {
+		// a server answering in some other protocol is no SOCKS5 server
+		proxy := startSocksServer(t, func(conn net.Conn, t *testing.T) {
+			defer conn.Close() //nolint: errcheck
+			buf := make([]byte, 3)
+			io.ReadFull(conn, buf)                             //nolint: errcheck
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n")) //nolint: errcheck
+		})
+		defer proxy.Close() //nolint: errcheck
+
+		err := dial("socks5://"+proxy.Addr().String(), "127.0.0.1:80")
+		require.ErrorIs(t, err, ErrHandshakeFailed)
+	})
+
+	t.Run("dial panic", func(t *testing.T) {
+		_, err := WithRecover(func() (net.Conn, error) {
+			panic("boom")
+		})
+		require.ErrorIs(t, err, ErrDialPanic)
+	})
+}
@@ -0,0 +1,438 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HealthState is the health of one proxy behind a Failover.
+type HealthState int
+
+const (
+	StateHealthy HealthState = iota
+	StateUnhealthy
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case StateHealthy:
+		return "healthy"
+	case StateUnhealthy:
+		return "unhealthy"
+	default:
+		return fmt.Sprintf("HealthState(%d)", int(s))
+	}
+}
+
+// Health is a snapshot of one proxy's health.
+type Health struct {
+	URL       string
+	State     HealthState
+	Failures  int // consecutive failures
+	LastError error
+	Changed   time.Time // time of the last state transition
+}
+
+// HealthEvent reports a state transition. Err is the failure that caused
+// the transition, or nil on recovery.
+type HealthEvent struct {
+	URL  string
+	From HealthState
+	To   HealthState
+	Err  error
+	At   time.Time
+}
+
+const (
+	defaultProbeInterval = 30 * time.Second
+	defaultProbeTimeout  = 10 * time.Second
+	defaultProbeURL      = "https://www.gstatic.com/generate_204"
+)
+
+type FailoverOption func(*Failover)
+
+// WithProxyOptions sets the options every proxy transport is built with.
+func WithProxyOptions(options ...Option) FailoverOption {
+	return func(f *Failover) {
+		f.options = options
+	}
+}
+
+// WithFailureThreshold sets how many consecutive failures mark a proxy
+// unhealthy. The default is 1.
+func WithFailureThreshold(n int) FailoverOption {
+	return func(f *Failover) {
+		if n > 0 {
+			f.threshold = n
+		}
+	}
+}
+
+// WithProbeInterval sets how often unhealthy proxies are re-probed. The
+// default is 30s.
+func WithProbeInterval(d time.Duration) FailoverOption {
+	return func(f *Failover) {
+		if d > 0 {
+			f.probeInterval = d
+		}
+	}
+}
+
+// WithProbeTimeout bounds a single active probe. The default is 10s.
+func WithProbeTimeout(d time.Duration) FailoverOption {
+	return func(f *Failover) {
+		if d > 0 {
+			f.probeTimeout = d
+		}
+	}
+}
+
+// WithProbeURL sets the URL requested through a proxy to confirm it has
+// recovered. Any response below 500 counts as a success.
+func WithProbeURL(u string) FailoverOption {
+	return func(f *Failover) {
+		f.probeURL = u
+	}
+}
+
+// WithHealthHandler registers fn to be called on every state transition.
+// fn runs synchronously and must not block.
+func WithHealthHandler(fn func(HealthEvent)) FailoverOption {
+	return func(f *Failover) {
+		f.onEvent = fn
+	}
+}
+
+type failoverBackend struct {
+	url       string
+	transport http.RoundTripper
+
+	mu        sync.Mutex
+	state     HealthState
+	failures  int
+	lastError error
+	changed   time.Time
+}
+
+// Failover is an http.RoundTripper that sends every request through the
+// first healthy proxy in priority order. A proxy is marked unhealthy after
+// it fails to carry requests (passive check) and is re-probed in the
+// background until it works again (active check). Failover is safe for
+// concurrent use; call Close to stop the probes.
+type Failover struct {
+	backends []*failoverBackend
+
+	options       []Option
+	registry      *Registry
+	threshold     int
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+	probeURL      string
+	onEvent       func(HealthEvent)
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewFailover creates a Failover over proxyURLs, highest priority first,
+// and starts its background probes.
+func NewFailover(proxyURLs []string, options ...FailoverOption) (*Failover, error) {
+	if len(proxyURLs) == 0 {
+		return nil, ErrEmptyPool
+	}
+
+	f := &Failover{
+		threshold:     1,
+		probeInterval: defaultProbeInterval,
+		probeTimeout:  defaultProbeTimeout,
+		probeURL:      defaultProbeURL,
+		stop:          make(chan struct{}),
+	}
+	for _, o := range options {
+		o(f)
+	}
+
+	opt := &Options{}
+	for _, o := range f.options {
+		o(opt)
+	}
+	f.registry = opt.registry()
+
+	now := time.Now()
+	for _, proxyURL := range proxyURLs {
+		tr, err := createProxyTransport(proxyURL, opt)
+		if err != nil {
+			f.closeTransports() //nolint: errcheck
+			return nil, err
+		}
+
+		f.backends = append(f.backends, &failoverBackend{
+			url:       proxyURL,
+			transport: tr,
+			changed:   now,
+		})
+	}
+
+	f.wg.Add(1)
+	go f.prober()
+
+	return f, nil
+}
+
+func (f *Failover) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Healthy proxies are tried first; unhealthy ones are a last resort,
+	// which beats failing outright when every proxy is marked down.
+	order := make([]*failoverBackend, 0, len(f.backends))
+	var unhealthy []*failoverBackend
+	for _, b := range f.backends {
+		if b.State() == StateHealthy {
+			order = append(order, b)
+		} else {
+			unhealthy = append(unhealthy, b)
+		}
+	}
+	order = append(order, unhealthy...)
+
+	var lastErr error
+	for i, b := range order {
+		if i > 0 {
+			r, err := rewindRequest(req)
+			if err != nil {
+				return nil, lastErr
+			}
+			req = r
+		}
+
+		resp, err := b.transport.RoundTrip(req)
+		if err == nil {
+			f.markSuccess(b)
+			return resp, nil
+		}
+
+		// The caller gave up; that says nothing about the proxy.
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, err
+		}
+
+		// Only failures of the proxy itself count against it; a target
+		// that is down or a config the scheme cannot use would fail the
+		// same way through any of them.
+		if isProxyFailure(err) {
+			f.markFailure(b, err)
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// isProxyFailure reports whether err says the proxy itself is not working.
+func isProxyFailure(err error) bool {
+	switch Classify(err) {
+	case ErrProxyUnreachable, ErrProxyAuthFailed, ErrHandshakeFailed:
+		return true
+	}
+	return false
+}
+
+// rewindRequest prepares req to be sent again through another proxy.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+
+	if req.GetBody == nil {
+		return nil, errors.New("proxyclient: request body cannot be replayed")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	r := req.Clone(req.Context())
+	r.Body = body
+	return r, nil
+}
+
+// Health returns the health of every proxy in priority order.
+func (f *Failover) Health() []Health {
+	items := make([]Health, len(f.backends))
+	for i, b := range f.backends {
+		b.mu.Lock()
+		items[i] = Health{
+			URL:       b.url,
+			State:     b.state,
+			Failures:  b.failures,
+			LastError: b.lastError,
+			Changed:   b.changed,
+		}
+		b.mu.Unlock()
+	}
+	return items
+}
+
+// CloseIdleConnections closes idle connections of every proxy.
+func (f *Failover) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+
+	for _, b := range f.backends {
+		if tr, ok := b.transport.(closeIdler); ok {
+			tr.CloseIdleConnections()
+		}
+	}
+}
+
+// Close stops the background probes and closes every proxy's transport.
+func (f *Failover) Close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		close(f.stop)
+		f.wg.Wait()
+		err = f.closeTransports()
+	})
+	f.wg.Wait()
+	return err
+}
+
+func (f *Failover) closeTransports() error {
+	rts := make([]http.RoundTripper, len(f.backends))
+	for i, b := range f.backends {
+		rts[i] = b.transport
+	}
+	return closeAll(rts)
+}
+
+func (b *failoverBackend) State() HealthState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (f *Failover) markSuccess(b *failoverBackend) {
+	b.mu.Lock()
+	b.failures = 0
+	b.lastError = nil
+	ev := f.transition(b, StateHealthy, nil)
+	b.mu.Unlock()
+
+	f.emit(ev)
+}
+
+func (f *Failover) markFailure(b *failoverBackend, err error) {
+	b.mu.Lock()
+	b.failures++
+	b.lastError = err
+	var ev *HealthEvent
+	if b.failures >= f.threshold {
+		ev = f.transition(b, StateUnhealthy, err)
+	}
+	b.mu.Unlock()
+
+	f.emit(ev)
+}
+
+// transition moves b to state and returns the event to emit, if any. The
+// caller holds b.mu.
+func (f *Failover) transition(b *failoverBackend, state HealthState, err error) *HealthEvent {
+	if b.state == state {
+		return nil
+	}
+
+	ev := &HealthEvent{
+		URL:  b.url,
+		From: b.state,
+		To:   state,
+		Err:  err,
+		At:   time.Now(),
+	}
+	b.state = state
+	b.changed = ev.At
+	return ev
+}
+
+func (f *Failover) emit(ev *HealthEvent) {
+	if ev != nil && f.onEvent != nil {
+		f.onEvent(*ev)
+	}
+}
+
+func (f *Failover) prober() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(f.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, b := range f.backends {
+			if b.State() != StateUnhealthy {
+				continue
+			}
+
+			if err := f.probe(b); err != nil {
+				f.markFailure(b, err)
+			} else {
+				f.markSuccess(b)
+			}
+		}
+	}
+}
+
+// probe checks b with a cheap reachability ping first and a real request
+// through the proxy second.
+func (f *Failover) probe(b *failoverBackend) error {
+	if host, port, scheme := proxyEndpoint(f.registry, b.url); host != "" && port != "" {
+		if !PingWithScheme(host, port, scheme, f.probeTimeout) {
+			return fmt.Errorf("proxyclient: %s:%s is unreachable", host, port)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.probeURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()        //nolint: errcheck
+	io.Copy(io.Discard, resp.Body) //nolint: errcheck
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("proxyclient: probe returned %s", resp.Status)
+	}
+	return nil
+}
+
+// proxyEndpoint returns the server address and scheme of a proxy URL,
+// using the parsers of r for share-link formats.
+func proxyEndpoint(r *Registry, proxyURL string) (host, port, scheme string) {
+	if u, err := r.ParseURL(proxyURL); err == nil {
+		return u.Host(), u.Port(), u.Protocol()
+	}
+
+	if u, err := url.Parse(proxyURL); err == nil {
+		return u.Hostname(), u.Port(), u.Scheme
+	}
+
+	return "", "", ""
+}
@@ -0,0 +1,174 @@
+package proxyclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startFlakyProxy starts an HTTP proxy that drops every connection while
+// down is set.
+func startFlakyProxy(t *testing.T, down *atomic.Bool) string {
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close() //nolint: errcheck
+			}
+			return
+		}
+
+		if r.Method == http.MethodConnect {
+			handleTunneling(w, r)
+		} else {
+			handleHTTP(w, r)
+		}
+	}))
+	t.Cleanup(proxyServer.Close)
+
+	return proxyServer.URL
+}
+
+func TestFailover(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from target server") //nolint: errcheck
+	}))
+	defer targetServer.Close()
+
+	get := func(t *testing.T, client *http.Client) {
+		resp, err := client.Get(targetServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint: errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello from target server", string(body))
+	}
+
+	t.Run("falls back and recovers", func(t *testing.T) {
+		var down atomic.Bool
+		down.Store(true)
+
+		primary := startFlakyProxy(t, &down)
+		backup, counts := startCountingProxies(t, 1)
+
+		var mu sync.Mutex
+		var events []HealthEvent
+
+		f, err := NewFailover([]string{primary, backup[0]},
+			WithProbeURL(targetServer.URL),
+			WithProbeInterval(50*time.Millisecond),
+			WithProbeTimeout(time.Second),
+			WithHealthHandler(func(ev HealthEvent) {
+				mu.Lock()
+				events = append(events, ev)
+				mu.Unlock()
+			}),
+			WithProxyOptions(WithTimeout(5*time.Second)),
+		)
+		require.NoError(t, err)
+		defer f.Close() //nolint: errcheck
+
+		client := &http.Client{Transport: f}
+
+		get(t, client)
+		require.Equal(t, int64(1), counts[0].Load())
+
+		health := f.Health()
+		require.Equal(t, StateUnhealthy, health[0].State)
+		require.ErrorIs(t, health[0].LastError, ErrHandshakeFailed)
+		require.Equal(t, StateHealthy, health[1].State)
+
+		// the unhealthy primary is skipped while the backup works
+		get(t, client)
+		require.Equal(t, int64(2), counts[0].Load())
+
+		down.Store(false)
+		require.Eventually(t, func() bool {
+			return f.Health()[0].State == StateHealthy
+		}, 5*time.Second, 20*time.Millisecond)
+
+		get(t, client)
+		require.Equal(t, int64(2), counts[0].Load())
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, events, 2)
+		require.Equal(t, primary, events[0].URL)
+		require.Equal(t, StateHealthy, events[0].From)
+		require.Equal(t, StateUnhealthy, events[0].To)
+		require.Error(t, events[0].Err)
+		require.Equal(t, StateUnhealthy, events[1].From)
+		require.Equal(t, StateHealthy, events[1].To)
+		require.NoError(t, events[1].Err)
+	})
+
+	t.Run("failure threshold", func(t *testing.T) {
+		var down atomic.Bool
+		down.Store(true)
+
+		primary := startFlakyProxy(t, &down)
+		backup, _ := startCountingProxies(t, 1)
+
+		f, err := NewFailover([]string{primary, backup[0]}, WithFailureThreshold(2))
+		require.NoError(t, err)
+		defer f.Close() //nolint: errcheck
+
+		client := &http.Client{Transport: f}
+
+		get(t, client)
+		require.Equal(t, StateHealthy, f.Health()[0].State)
+		require.Equal(t, 1, f.Health()[0].Failures)
+
+		get(t, client)
+		require.Equal(t, StateUnhealthy, f.Health()[0].State)
+	})
+
+	t.Run("all down", func(t *testing.T) {
+		var down atomic.Bool
+		down.Store(true)
+
+		f, err := NewFailover([]string{startFlakyProxy(t, &down), startFlakyProxy(t, &down)})
+		require.NoError(t, err)
+		defer f.Close() //nolint: errcheck
+
+		client := &http.Client{Transport: f}
+
+		_, err = client.Get(targetServer.URL)
+		require.Error(t, err)
+
+		// unhealthy proxies are still tried as a last resort
+		down.Store(false)
+		get(t, client)
+	})
+
+	t.Run("target errors do not count", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks5)
+		defer proxy.Close() //nolint: errcheck
+
+		f, err := NewFailover([]string{fmt.Sprintf("socks5://%s", proxy.Addr())})
+		require.NoError(t, err)
+		defer f.Close() //nolint: errcheck
+
+		client := &http.Client{Transport: f}
+
+		_, err = client.Get("http://127.0.0.1:1")
+		require.ErrorIs(t, err, ErrTargetUnreachable)
+
+		health := f.Health()
+		require.Equal(t, StateHealthy, health[0].State)
+		require.Zero(t, health[0].Failures)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := NewFailover(nil)
+		require.ErrorIs(t, err, ErrEmptyPool)
+	})
+}
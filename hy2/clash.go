@@ -0,0 +1,105 @@
+package hy2
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cnlangzi/proxyclient"
+)
+
+func init() {
+	proxyclient.RegisterClashParser("hysteria2", func(e *proxyclient.ClashEntry) (proxyclient.URL, error) {
+		return FromClash(e)
+	})
+
+	marshal := func(u proxyclient.URL) (map[string]any, error) {
+		v, ok := u.(*HY2URL)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", proxyclient.ErrUnsupportedClashType, u.Protocol())
+		}
+		return ToClash(v.Config)
+	}
+	proxyclient.RegisterClashMarshaler("hysteria2", marshal)
+	proxyclient.RegisterClashMarshaler("hy2", marshal)
+}
+
+// FromClash converts a Clash hysteria2 entry into an HY2URL.
+func FromClash(e *proxyclient.ClashEntry) (*HY2URL, error) {
+	cfg := &HY2Config{
+		Auth:         e.String("password"),
+		Address:      e.String("server"),
+		Port:         e.Int("port"),
+		SNI:          e.String("sni"),
+		Insecure:     e.Bool("skip-cert-verify"),
+		ObfsType:     e.String("obfs"),
+		ObfsPassword: e.String("obfs-password"),
+		Up:           clashBandwidth(e.String("up")),
+		Down:         clashBandwidth(e.String("down")),
+		Remark:       e.String("name"),
+	}
+
+	if cfg.SNI == "" {
+		cfg.SNI = cfg.Address
+	}
+
+	u := &HY2URL{Config: cfg}
+	raw, err := url.Parse(u.Encode())
+	if err != nil {
+		return nil, err
+	}
+	cfg.raw = raw
+
+	return u, nil
+}
+
+// clashBandwidth adds the unit Clash implies for a bare number, Mbps.
+func clashBandwidth(s string) string {
+	s = strings.TrimSpace(s)
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s + " mbps"
+	}
+	return s
+}
+
+// ToClash converts an HY2Config into a Clash hysteria2 entry.
+func ToClash(cfg *HY2Config) (map[string]any, error) {
+	p := map[string]any{
+		"name":     cfg.Remark,
+		"type":     "hysteria2",
+		"server":   cfg.Address,
+		"port":     cfg.Port,
+		"password": cfg.Auth,
+	}
+
+	if cfg.SNI != "" && cfg.SNI != cfg.Address {
+		p["sni"] = cfg.SNI
+	}
+	if cfg.Insecure {
+		p["skip-cert-verify"] = true
+	}
+	if cfg.ObfsType != "" {
+		p["obfs"] = cfg.ObfsType
+		p["obfs-password"] = cfg.ObfsPassword
+	}
+	if cfg.Up != "" {
+		p["up"] = cfg.Up
+	}
+	if cfg.Down != "" {
+		p["down"] = cfg.Down
+	}
+
+	var dropped []string
+	if cfg.ObfsMinPacketSize > 0 {
+		dropped = append(dropped, "obfs-min-packet-size")
+	}
+	if cfg.ObfsMaxPacketSize > 0 {
+		dropped = append(dropped, "obfs-max-packet-size")
+	}
+	if cfg.FastOpen {
+		dropped = append(dropped, "fastopen")
+	}
+
+	return p, proxyclient.UnsupportedClashFields(dropped...)
+}
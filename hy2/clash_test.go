@@ -0,0 +1,59 @@
+package hy2
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClash(t *testing.T) {
+	config := `
+proxies:
+  - name: hy2
+    type: hysteria2
+    server: hy2.example.com
+    port: 443
+    password: secret
+    sni: sni.example.com
+    skip-cert-verify: true
+    obfs: salamander
+    obfs-password: obfs-secret
+    up: 30
+    down: "200 Mbps"
+    ports: 443-8443
+`
+
+	items, errs := proxyclient.ParseClash(strings.NewReader(config))
+	require.Len(t, items, 1)
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], proxyclient.ErrUnsupportedClashField)
+	require.Contains(t, errs[0].Error(), "ports")
+
+	cfg := items[0].(*HY2URL).Config
+	require.Equal(t, "secret", cfg.Auth)
+	require.Equal(t, "hy2.example.com", cfg.Address)
+	require.Equal(t, 443, cfg.Port)
+	require.Equal(t, "sni.example.com", cfg.SNI)
+	require.True(t, cfg.Insecure)
+	require.Equal(t, "salamander", cfg.ObfsType)
+	require.Equal(t, "obfs-secret", cfg.ObfsPassword)
+	require.Equal(t, "30 mbps", cfg.Up)
+	require.Equal(t, "200 Mbps", cfg.Down)
+
+	parsed, err := proxyclient.ParseURL(items[0].Raw().String())
+	require.NoError(t, err)
+
+	got := *parsed.(*HY2URL).Config
+	want := *cfg
+	want.raw, got.raw = nil, nil
+	require.Equal(t, want, got)
+
+	buf, errs := proxyclient.MarshalClash(items)
+	require.Empty(t, errs)
+
+	again, errs := proxyclient.ParseClash(strings.NewReader(string(buf)))
+	require.Empty(t, errs)
+	require.Equal(t, items[0].Raw().String(), again[0].Raw().String())
+}
@@ -179,4 +179,64 @@ func ParseHY2URL(u *url.URL) (*HY2URL, error) {
 	}
 
 	return &HY2URL{Config: config}, nil
-}
\ No newline at end of file
+}
+
+// Encode returns the canonical link of Config, keeping the hysteria2:// or
+// hy2:// scheme it was parsed from. ParseHY2URL reads it back into an
+// identical Config.
+func (h *HY2URL) Encode() string {
+	if h.Config == nil {
+		return ""
+	}
+	c := h.Config
+
+	scheme := "hysteria2"
+	if c.raw != nil && c.raw.Scheme != "" {
+		scheme = c.raw.Scheme
+	}
+
+	q := url.Values{}
+	if c.SNI != "" && c.SNI != c.Address {
+		q.Set("sni", c.SNI)
+	}
+	if c.Insecure {
+		q.Set("insecure", "1")
+	}
+	if c.ObfsType != "" {
+		q.Set("obfs", c.ObfsType)
+	}
+	if c.ObfsPassword != "" {
+		q.Set("obfs-password", c.ObfsPassword)
+	}
+	if c.ObfsMinPacketSize > 0 {
+		q.Set("obfs-min-packet-size", strconv.Itoa(c.ObfsMinPacketSize))
+	}
+	if c.ObfsMaxPacketSize > 0 {
+		q.Set("obfs-max-packet-size", strconv.Itoa(c.ObfsMaxPacketSize))
+	}
+	if c.Up != "" {
+		q.Set("up", c.Up)
+	}
+	if c.Down != "" {
+		q.Set("down", c.Down)
+	}
+	if c.FastOpen {
+		q.Set("fastopen", "1")
+	}
+
+	u := &url.URL{
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(c.Address, strconv.Itoa(c.Port)),
+		RawQuery: q.Encode(),
+		Fragment: c.Remark,
+	}
+	if c.Auth != "" {
+		u.User = url.User(c.Auth)
+	}
+
+	return u.String()
+}
+
+func (h *HY2URL) String() string {
+	return h.Encode()
+}
@@ -2,6 +2,7 @@ package hy2
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/apernet/hysteria/core/v2/client"
+	coreErrs "github.com/apernet/hysteria/core/v2/errors"
 	"github.com/apernet/hysteria/extras/v2/obfs"
 	"github.com/cnlangzi/proxyclient"
 )
@@ -16,6 +18,11 @@ import (
 func init() {
 	proxyclient.RegisterProxy("hysteria2", DialHY2)
 	proxyclient.RegisterProxy("hy2", DialHY2)
+	proxyclient.RegisterDialer("hysteria2", HY2Dialer)
+	proxyclient.RegisterDialer("hy2", HY2Dialer)
+	// The capabilities of hysteria2 are registered by proxyclient itself,
+	// so that PingWithScheme knows its servers listen on UDP without this
+	// package.
 }
 
 // obfsConnFactory implements client.ConnFactory interface.
@@ -27,12 +34,20 @@ type obfsConnFactory struct {
 	ObfsPassword      string
 	ObfsMinPacketSize int
 	ObfsMaxPacketSize int
+	// Forward relays the QUIC packets through an upstream proxy when set.
+	Forward proxyclient.PacketDialer
 }
 
 // New creates a new obfuscated UDP connection
 func (f *obfsConnFactory) New(_ net.Addr) (net.PacketConn, error) {
 	// Create raw UDP conn - each call gets a fresh connection
-	conn, err := net.ListenUDP("udp", nil)
+	var conn net.PacketConn
+	var err error
+	if f.Forward != nil {
+		conn, err = f.Forward.ListenPacket(context.Background(), "udp")
+	} else {
+		conn, err = net.ListenUDP("udp", nil)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -62,19 +77,20 @@ func (f *obfsConnFactory) New(_ net.Addr) (net.PacketConn, error) {
 	return obfuscated, nil
 }
 
-// DialHY2 creates a RoundTripper for Hysteria2 proxy
-func DialHY2(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
+// HY2Dialer creates a dialer that opens TCP streams over a Hysteria2
+// connection.
+func HY2Dialer(u *url.URL, o *proxyclient.Options) (proxyclient.ContextDialer, error) {
 	// Parse HY2 URL
 	hy2URL, err := ParseHY2URL(u)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse HY2 URL: %w", err)
+		return nil, fmt.Errorf("%w: failed to parse HY2 URL: %w", proxyclient.ErrConfigUnsupported, err)
 	}
 	cfg := hy2URL.Config
 
 	// Resolve server address
 	serverAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(cfg.Address, fmt.Sprintf("%d", cfg.Port)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve server address: %w", err)
+		return nil, fmt.Errorf("%w: failed to resolve server address: %w", proxyclient.ErrProxyUnreachable, err)
 	}
 
 	// Build hysteria client config
@@ -92,10 +108,20 @@ func DialHY2(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
 	if cfg.Up != "" || cfg.Down != "" {
 		hyConfig.BandwidthConfig, err = parseBandwidth(cfg.Up, cfg.Down)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse bandwidth: %w", err)
+			return nil, fmt.Errorf("%w: failed to parse bandwidth: %w", proxyclient.ErrConfigUnsupported, err)
 		}
 	}
 
+	// QUIC runs over UDP, so an upstream hop must be able to relay packets.
+	var forward proxyclient.PacketDialer
+	if o.Forward != nil {
+		pd, ok := o.Forward.(proxyclient.PacketDialer)
+		if !ok {
+			return nil, fmt.Errorf("hy2 needs a UDP capable forward dialer: %w", proxyclient.ErrChainUnsupported)
+		}
+		forward = pd
+	}
+
 	// Apply obfuscation if configured
 	if cfg.ObfsType != "" && cfg.ObfsPassword != "" {
 		hyConfig.ConnFactory = &obfsConnFactory{
@@ -103,23 +129,93 @@ func DialHY2(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
 			ObfsPassword:      cfg.ObfsPassword,
 			ObfsMinPacketSize: cfg.ObfsMinPacketSize,
 			ObfsMaxPacketSize: cfg.ObfsMaxPacketSize,
+			Forward:           forward,
+		}
+	} else if forward != nil {
+		hyConfig.ConnFactory = &obfsConnFactory{
+			Forward: forward,
 		}
 	}
 
 	// Create HY2 client
 	hy2Client, _, err := client.NewClient(hyConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HY2 client: %w", err)
+		return nil, fmt.Errorf("failed to create HY2 client: %w", classify(err))
 	}
 
-	// Create transport with custom dial
-	tr := proxyclient.CreateTransport(o)
-	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		return hy2Client.TCP(addr)
+	return &dialer{
+		UDPDialer: proxyclient.UDPDialer{
+			Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := hy2Client.TCP(addr)
+				if err != nil {
+					return nil, classify(err)
+				}
+				return conn, nil
+			},
+			ListenPacketFunc: func(ctx context.Context, network string) (net.PacketConn, error) {
+				// UDP relaying has to be enabled on the server
+				conn, err := hy2Client.UDP()
+				if err != nil {
+					var dialErr coreErrs.DialError
+					if errors.As(err, &dialErr) {
+						return nil, fmt.Errorf("hy2: %w: %w", proxyclient.ErrUDPUnsupported, err)
+					}
+					return nil, classify(err)
+				}
+				return proxyclient.NewPacketConn(conn), nil
+			},
+		},
+		client: hy2Client,
+	}, nil
+}
+
+// dialer opens streams and UDP sessions over one Hysteria2 connection.
+type dialer struct {
+	proxyclient.UDPDialer
+	client client.Client
+}
+
+// Close tears down the Hysteria2 connection.
+func (d *dialer) Close() error {
+	return d.client.Close()
+}
+
+// classify wraps an error from the hysteria client with the proxyclient
+// error it stands for.
+func classify(err error) error {
+	var (
+		configErr  coreErrs.ConfigError
+		connectErr coreErrs.ConnectError
+		authErr    coreErrs.AuthError
+		dialErr    coreErrs.DialError
+		closedErr  coreErrs.ClosedError
+	)
+
+	kind := proxyclient.ErrHandshakeFailed
+	switch {
+	case errors.As(err, &configErr):
+		kind = proxyclient.ErrConfigUnsupported
+	case errors.As(err, &connectErr), errors.As(err, &closedErr):
+		kind = proxyclient.ErrProxyUnreachable
+	case errors.As(err, &authErr):
+		kind = proxyclient.ErrProxyAuthFailed
+	case errors.As(err, &dialErr):
+		kind = proxyclient.ErrTargetUnreachable
 	}
-	tr.Proxy = nil
 
-	return tr, nil
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// DialHY2 creates a RoundTripper for Hysteria2 proxy
+func DialHY2(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
+	d, err := HY2Dialer(u, o)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := proxyclient.CreateDialerTransport(d, o)
+
+	return proxyclient.ClosableTransport(tr, d), nil
 }
 
 // parseBandwidth converts bandwidth strings to client.BandwidthConfig
@@ -187,4 +283,4 @@ func parseBandwidthValue(s string) (uint64, error) {
 	}
 
 	return uint64(val * float64(multiplier)), nil
-}
\ No newline at end of file
+}
@@ -42,3 +42,8 @@ func (i *Int) UnmarshalJSON(data []byte) error {
 func (i Int) Value() int {
 	return i.v
 }
+
+// NewInt returns an Int holding v.
+func NewInt(v int) Int {
+	return Int{v: v}
+}
@@ -0,0 +1,159 @@
+package proxyclient
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Observer is told about every connection a client opens through its
+// proxy, so that callers can keep latency and traffic statistics per proxy.
+// proxy names the proxy the client was built for as scheme://host:port,
+// without credentials, and addr is the address the connection was asked
+// for. Methods may be called concurrently.
+type Observer interface {
+	// OnDialStart is called before a connection is opened.
+	OnDialStart(proxy, network, addr string)
+	// OnDialDone is called once the proxy server itself has been reached,
+	// or could not be.
+	OnDialDone(proxy, network, addr string, elapsed time.Duration, err error)
+	// OnHandshakeDone is called once the proxy has opened the tunnel to
+	// addr, or failed to. elapsed counts from OnDialStart.
+	OnHandshakeDone(proxy, network, addr string, elapsed time.Duration, err error)
+	// OnConnClosed is called when a connection that was handed out is
+	// closed, with the bytes written to and read from it.
+	OnConnClosed(proxy, network, addr string, sent, received int64)
+}
+
+// WithObserver makes o see the connections of the client.
+func WithObserver(o Observer) Option {
+	return func(opt *Options) {
+		opt.Observer = o
+	}
+}
+
+type dialTraceKey struct{}
+
+// dialTrace follows a single observed dial, so that the forward dialer can
+// report when the proxy server has been reached.
+type dialTrace struct {
+	observer Observer
+	proxy    string
+	network  string
+	addr     string
+	start    time.Time
+	done     atomic.Bool
+}
+
+func (t *dialTrace) dialDone(err error) {
+	if t.done.CompareAndSwap(false, true) {
+		t.observer.OnDialDone(t.proxy, t.network, t.addr, time.Since(t.start), err)
+	}
+}
+
+// ObserveDialer returns a dialer that reports every connection d opens for
+// proxy to o.Observer. It returns d itself when there is no observer.
+// Schemes whose library reaches the proxy server on its own report
+// OnDialDone and OnHandshakeDone together, once the tunnel is up.
+//
+// New, NewDialer and CreateDialerTransport observe their dialers already;
+// ObserveDialer is for transports built by other means.
+func ObserveDialer(proxy string, o *Options, d ContextDialer) ContextDialer {
+	observer := o.Observer
+	if observer == nil {
+		return d
+	}
+
+	return wrapDialer(d, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		t := &dialTrace{
+			observer: observer,
+			proxy:    proxy,
+			network:  network,
+			addr:     addr,
+			start:    time.Now(),
+		}
+
+		observer.OnDialStart(proxy, network, addr)
+		conn, err := d.DialContext(context.WithValue(ctx, dialTraceKey{}, t), network, addr)
+		t.dialDone(err)
+		observer.OnHandshakeDone(proxy, network, addr, time.Since(t.start), err)
+		if err != nil {
+			return nil, err
+		}
+
+		return &observedConn{
+			Conn: conn,
+			onClose: func(sent, received int64) {
+				observer.OnConnClosed(proxy, network, addr, sent, received)
+			},
+		}, nil
+	}, nil)
+}
+
+// proxyLabel names u for observers as scheme://host:port, leaving out the
+// credentials and settings a proxy URL carries. Schemes that keep their
+// server in an encoded blob, such as vmess, are parsed with r to find it.
+func proxyLabel(r *Registry, u *url.URL) string {
+	host, port := u.Hostname(), u.Port()
+	if parse, ok := r.LookupParser(u.Scheme); ok {
+		host, port = "", ""
+		if pu, err := parse(u); err == nil {
+			host, port = pu.Host(), pu.Port()
+		}
+	}
+
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return strings.ToLower(u.Scheme) + "://" + host
+}
+
+// traceForward reports to the dial being observed, if any, once forward
+// has reached the proxy server.
+func traceForward(forward ContextDialer) ContextDialer {
+	return Dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		t, _ := ctx.Value(dialTraceKey{}).(*dialTrace)
+		if t == nil {
+			return forward.DialContext(ctx, network, addr)
+		}
+
+		// earlier hops of a chain reaching their own servers are not
+		// what t waits for
+		conn, err := forward.DialContext(context.WithValue(ctx, dialTraceKey{}, (*dialTrace)(nil)), network, addr)
+		t.dialDone(err)
+		return conn, err
+	})
+}
+
+// observedConn counts the bytes that pass through a connection.
+type observedConn struct {
+	net.Conn
+	sent     atomic.Int64
+	received atomic.Int64
+	once     sync.Once
+	onClose  func(sent, received int64)
+}
+
+func (c *observedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *observedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+func (c *observedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.onClose(c.sent.Load(), c.received.Load())
+	})
+	return err
+}
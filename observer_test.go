@@ -0,0 +1,123 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingObserver struct {
+	mu       sync.Mutex
+	events   []string
+	proxy    string
+	sent     int64
+	received int64
+}
+
+func (o *recordingObserver) record(event string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *recordingObserver) OnDialStart(proxy, network, addr string) {
+	o.mu.Lock()
+	o.proxy = proxy
+	o.mu.Unlock()
+	o.record("start")
+}
+
+func (o *recordingObserver) OnDialDone(proxy, network, addr string, elapsed time.Duration, err error) {
+	o.record(fmt.Sprintf("dial %v", err == nil))
+}
+
+func (o *recordingObserver) OnHandshakeDone(proxy, network, addr string, elapsed time.Duration, err error) {
+	o.record(fmt.Sprintf("handshake %v", err == nil))
+}
+
+func (o *recordingObserver) OnConnClosed(proxy, network, addr string, sent, received int64) {
+	o.mu.Lock()
+	o.sent, o.received = sent, received
+	o.mu.Unlock()
+	o.record("closed")
+}
+
+func (o *recordingObserver) Events() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.events...)
+}
+
+func TestObserver(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from target server") //nolint: errcheck
+	}))
+	defer targetServer.Close()
+
+	proxy := startSocksServer(t, handleSocks5)
+	defer proxy.Close() //nolint: errcheck
+
+	t.Run("socks5", func(t *testing.T) {
+		ob := &recordingObserver{}
+		c, err := New("socks5://"+proxy.Addr().String(), WithObserver(ob), WithTransport(&http.Transport{DisableKeepAlives: true}))
+		require.NoError(t, err)
+
+		resp, err := c.Get(targetServer.URL)
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close() //nolint: errcheck
+
+		require.Eventually(t, func() bool {
+			return len(ob.Events()) == 4
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, []string{"start", "dial true", "handshake true", "closed"}, ob.Events())
+		require.Positive(t, ob.sent)
+		require.Positive(t, ob.received)
+	})
+
+	t.Run("proxy unreachable", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		dead := ln.Addr().String()
+		ln.Close() //nolint: errcheck
+
+		ob := &recordingObserver{}
+		c, err := New("socks5://"+dead, WithObserver(ob))
+		require.NoError(t, err)
+
+		_, err = c.Get(targetServer.URL) //nolint: bodyclose
+		require.Error(t, err)
+		require.Equal(t, []string{"start", "dial false", "handshake false"}, ob.Events())
+	})
+
+	t.Run("dialer", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		dead := ln.Addr().String()
+		ln.Close() //nolint: errcheck
+
+		ob := &recordingObserver{}
+		d, err := NewDialer("socks5://alice:secret@"+dead+"?x=1", WithObserver(ob))
+		require.NoError(t, err)
+
+		_, err = d.DialContext(context.Background(), "tcp", "example.com:80")
+		require.Error(t, err)
+		require.Equal(t, []string{"start", "dial false", "handshake false"}, ob.Events())
+		// the label leaves the credentials out
+		require.Equal(t, "socks5://"+dead, ob.proxy)
+	})
+
+	t.Run("no observer", func(t *testing.T) {
+		d := &net.Dialer{}
+		require.Same(t, d, ObserveDialer("socks5://"+proxy.Addr().String(), &Options{}, d))
+	})
+}
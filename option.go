@@ -1,14 +1,35 @@
 package proxyclient
 
 import (
+	"net"
 	"net/http"
+	"net/url"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 type Options struct {
 	Timeout   time.Duration
 	Client    *http.Client
 	Transport *http.Transport
+	// Forward is the dialer used to reach the proxy server itself. It is nil
+	// for a direct connection and set to the previous hop inside a chain.
+	Forward ContextDialer
+	// Observer, when set, is told about every connection the client opens.
+	Observer Observer
+	// Upload and Download, when set, throttle the bytes sent and received
+	// by all connections of the client. See WithRateLimit.
+	Upload   *rate.Limiter
+	Download *rate.Limiter
+	// Resolver, when set, resolves the target host names that a scheme
+	// cannot send to its proxy. See WithResolver.
+	Resolver *net.Resolver
+	// Registry, when set, replaces DefaultRegistry. See WithRegistry.
+	Registry *Registry
+
+	// proxy labels the proxy for the observer; see setProxy.
+	proxy string
 }
 
 type Option func(*Options)
@@ -25,6 +46,41 @@ func WithTransport(tr *http.Transport) Option {
 	}
 }
 
+// WithForward makes the proxy server be reached through d instead of a
+// direct connection.
+func WithForward(d ContextDialer) Option {
+	return func(o *Options) {
+		o.Forward = d
+	}
+}
+
+// ForwardDialer returns the dialer to use for reaching the proxy server.
+func (o *Options) ForwardDialer() ContextDialer {
+	var d ContextDialer = &net.Dialer{Timeout: o.Timeout}
+	if o.Forward != nil {
+		d = o.Forward
+	}
+
+	if o.Observer != nil {
+		return traceForward(d)
+	}
+
+	return d
+}
+
+// setProxy records which proxy the options are building a client or dialer
+// for, so that its connections are reported under a label without secrets.
+func (o *Options) setProxy(u *url.URL) {
+	if o.Observer != nil {
+		o.proxy = proxyLabel(o.registry(), u)
+	}
+}
+
+// instrument makes d report to the observer and obey the rate limit.
+func (o *Options) instrument(d ContextDialer) ContextDialer {
+	return limitDialer(o, ObserveDialer(o.proxy, o, d))
+}
+
 func WithTimeout(d time.Duration) Option {
 	return func(o *Options) {
 		if d > 0 {
@@ -0,0 +1,277 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListenPacket returns a PacketConn that relays UDP datagrams through
+// proxyURL. Every WriteTo is sent to the given address by the proxy, and
+// ReadFrom reports the address each reply came from. It returns
+// ErrUDPUnsupported for schemes that can only carry TCP. Closing the
+// PacketConn releases the dialer of proxyURL as well.
+//
+// Addresses may be a *net.UDPAddr or a HostAddr; a HostAddr leaves the
+// name resolution to the proxy.
+func ListenPacket(ctx context.Context, proxyURL string, options ...Option) (net.PacketConn, error) {
+	d, err := NewDialer(proxyURL, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	pd, ok := d.(PacketDialer)
+	if !ok {
+		closeDialer(d) //nolint: errcheck
+		u, _ := url.Parse(proxyURL)
+		return nil, fmt.Errorf("%s: %w", strings.ToLower(u.Scheme), ErrUDPUnsupported)
+	}
+
+	pc, err := pd.ListenPacket(ctx, "udp")
+	if err != nil {
+		closeDialer(d) //nolint: errcheck
+		return nil, err
+	}
+
+	if c, ok := d.(io.Closer); ok {
+		return &dialerPacketConn{PacketConn: pc, dialer: c}, nil
+	}
+	return pc, nil
+}
+
+// dialerPacketConn closes the dialer it was made with after itself.
+type dialerPacketConn struct {
+	net.PacketConn
+	dialer io.Closer
+	once   sync.Once
+}
+
+func (c *dialerPacketConn) Close() error {
+	err := c.PacketConn.Close()
+	c.once.Do(func() {
+		c.dialer.Close() //nolint: errcheck
+	})
+	return err
+}
+
+// HostAddr is an unresolved "host:port" UDP address.
+type HostAddr string
+
+func (a HostAddr) Network() string { return "udp" }
+func (a HostAddr) String() string  { return string(a) }
+
+// packetAddr turns a "host:port" reported by a proxy into a net.Addr,
+// keeping domain names as a HostAddr.
+func packetAddr(addr string) net.Addr {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return net.UDPAddrFromAddrPort(ap)
+	}
+
+	return HostAddr(addr)
+}
+
+// checkUDPNetwork rejects anything but the udp networks.
+func checkUDPNetwork(network string) error {
+	switch network {
+	case "udp", "udp4", "udp6":
+		return nil
+	}
+
+	return fmt.Errorf("unsupported network: %s", network)
+}
+
+// DatagramConn is a message based UDP relay, such as the one returned by
+// hysteria's client.UDP(). Receive blocks until a datagram arrives or the
+// relay is closed.
+type DatagramConn interface {
+	Receive() ([]byte, string, error)
+	Send(b []byte, addr string) error
+	Close() error
+}
+
+type datagram struct {
+	b    []byte
+	addr string
+}
+
+// NewPacketConn adapts c to net.PacketConn, adding the deadline support
+// DatagramConn lacks.
+func NewPacketConn(c DatagramConn) net.PacketConn {
+	pc := &datagramPacketConn{
+		conn:          c,
+		packets:       make(chan datagram),
+		done:          make(chan struct{}),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+	}
+
+	go pc.receive()
+
+	return pc
+}
+
+type datagramPacketConn struct {
+	conn    DatagramConn
+	packets chan datagram
+	done    chan struct{}
+	once    sync.Once
+
+	mu  sync.Mutex
+	err error
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+}
+
+func (c *datagramPacketConn) receive() {
+	for {
+		b, addr, err := c.conn.Receive()
+		if err != nil {
+			if isClosedChan(c.done) {
+				return
+			}
+
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			c.Close() //nolint: errcheck
+			return
+		}
+
+		select {
+		case c.packets <- datagram{b, addr}:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *datagramPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case d := <-c.packets:
+		return copy(p, d.b), packetAddr(d.addr), nil
+	case <-c.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.err != nil {
+			return 0, nil, c.err
+		}
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *datagramPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	if err := c.conn.Send(p, addr.String()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *datagramPacketConn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// LocalAddr reports the unspecified address; the socket that carries the
+// datagrams belongs to the proxy.
+func (c *datagramPacketConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero}
+}
+
+func (c *datagramPacketConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *datagramPacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *datagramPacketConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// deadline is a channel that is closed once a point in time has passed,
+// in the same way as the one behind net.Pipe.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,233 @@
+package proxyclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startUDPEchoServer starts a UDP server that sends every datagram back.
+func startUDPEchoServer(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr) //nolint: errcheck
+		}
+	}()
+
+	return pc
+}
+
+// handleSocks5UDP is a minimal SOCKS5 server that only knows UDP ASSOCIATE.
+func handleSocks5UDP(conn net.Conn, t *testing.T) {
+	defer conn.Close() //nolint: errcheck
+
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{socks5Version, socks5AuthNone}) //nolint: errcheck
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != socks5CmdUDPAssociate {
+		return
+	}
+	if _, err := readSocks5Addr(conn, buf[3]); err != nil {
+		return
+	}
+
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer relay.Close() //nolint: errcheck
+
+	reply, _ := appendSocks5Addr([]byte{socks5Version, Socks5Succeeded, 0}, relay.LocalAddr().String())
+	conn.Write(reply) //nolint: errcheck
+
+	go func() {
+		var client net.Addr
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := relay.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if client == nil || from.String() == client.String() {
+				client = from
+				r := bytes.NewReader(buf[4:n])
+				addr, err := readSocks5Addr(r, buf[3])
+				if err != nil {
+					continue
+				}
+				target, err := net.ResolveUDPAddr("udp", addr)
+				if err != nil {
+					continue
+				}
+				relay.WriteTo(buf[n-r.Len():n], target) //nolint: errcheck
+				continue
+			}
+
+			b, _ := appendSocks5Addr([]byte{0, 0, 0}, from.String())
+			relay.WriteTo(append(b, buf[:n]...), client) //nolint: errcheck
+		}
+	}()
+
+	// the association ends with the control connection
+	io.Copy(io.Discard, conn) //nolint: errcheck
+}
+
+func requireUDPEcho(t *testing.T, pc net.PacketConn, addr net.Addr) {
+	pc.SetDeadline(time.Now().Add(5 * time.Second)) //nolint: errcheck
+
+	_, err := pc.WriteTo([]byte("ping"), addr)
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf[:n]))
+	require.Equal(t, addr.String(), from.String())
+}
+
+func TestListenPacket(t *testing.T) {
+	echo := startUDPEchoServer(t)
+	defer echo.Close() //nolint: errcheck
+
+	t.Run("socks5", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks5UDP)
+		defer proxy.Close() //nolint: errcheck
+
+		pc, err := ListenPacket(context.Background(), fmt.Sprintf("socks5://%s", proxy.Addr()))
+		require.NoError(t, err)
+		defer pc.Close() //nolint: errcheck
+
+		requireUDPEcho(t, pc, echo.LocalAddr())
+	})
+
+	t.Run("socks5 host addr", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks5UDP)
+		defer proxy.Close() //nolint: errcheck
+
+		pc, err := ListenPacket(context.Background(), fmt.Sprintf("socks5://%s", proxy.Addr()))
+		require.NoError(t, err)
+		defer pc.Close() //nolint: errcheck
+
+		port := echo.LocalAddr().(*net.UDPAddr).Port
+		_, err = pc.WriteTo([]byte("ping"), HostAddr(fmt.Sprintf("localhost:%d", port)))
+		require.NoError(t, err)
+
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint: errcheck
+		buf := make([]byte, 16)
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf[:n]))
+	})
+
+	t.Run("socks5 closed by server", func(t *testing.T) {
+		proxy := startSocksServer(t, func(conn net.Conn, t *testing.T) {
+			handleSocks5UDP(&closeAfterReply{Conn: conn}, t)
+		})
+		defer proxy.Close() //nolint: errcheck
+
+		pc, err := ListenPacket(context.Background(), fmt.Sprintf("socks5://%s", proxy.Addr()))
+		require.NoError(t, err)
+
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint: errcheck
+		_, _, err = pc.ReadFrom(make([]byte, 16))
+		require.ErrorIs(t, err, net.ErrClosed)
+	})
+
+	t.Run("tcp only", func(t *testing.T) {
+		_, err := ListenPacket(context.Background(), "http://127.0.0.1:8080")
+		require.ErrorIs(t, err, ErrUDPUnsupported)
+	})
+}
+
+// closeAfterReply closes the control connection right after the server
+// has answered UDP ASSOCIATE.
+type closeAfterReply struct {
+	net.Conn
+}
+
+func (c *closeAfterReply) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if len(b) > 2 {
+		c.Conn.Close() //nolint: errcheck
+	}
+	return n, err
+}
+
+// fakeDatagramConn is a DatagramConn that echoes whatever is sent.
+type fakeDatagramConn struct {
+	in     chan datagram
+	closed chan struct{}
+}
+
+func (c *fakeDatagramConn) Receive() ([]byte, string, error) {
+	select {
+	case d := <-c.in:
+		return d.b, d.addr, nil
+	case <-c.closed:
+		return nil, "", errors.New("closed")
+	}
+}
+
+func (c *fakeDatagramConn) Send(b []byte, addr string) error {
+	c.in <- datagram{b, addr}
+	return nil
+}
+
+func (c *fakeDatagramConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestNewPacketConn(t *testing.T) {
+	pc := NewPacketConn(&fakeDatagramConn{in: make(chan datagram, 1), closed: make(chan struct{})})
+
+	_, err := pc.WriteTo([]byte("ping"), HostAddr("example.com:53"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	n, addr, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf[:n]))
+	require.Equal(t, HostAddr("example.com:53"), addr)
+
+	_, err = pc.WriteTo([]byte("pong"), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 53})
+	require.NoError(t, err)
+	_, addr, err = pc.ReadFrom(buf)
+	require.NoError(t, err)
+	require.IsType(t, &net.UDPAddr{}, addr)
+
+	pc.SetReadDeadline(time.Now().Add(20 * time.Millisecond)) //nolint: errcheck
+	_, _, err = pc.ReadFrom(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	pc.SetReadDeadline(time.Time{}) //nolint: errcheck
+	require.NoError(t, pc.Close())
+
+	_, _, err = pc.ReadFrom(buf)
+	require.Error(t, err)
+	_, err = pc.WriteTo([]byte("ping"), HostAddr("example.com:53"))
+	require.ErrorIs(t, err, net.ErrClosed)
+}
@@ -21,16 +21,16 @@ const defaultPingCap = 1500 * time.Millisecond
 // dispatch.
 //
 // The returned bool is best-effort: even if the kernel returns a SYN-ACK,
-// the remote service may still be dead. The caller is expected to run a
-// full status probe afterwards; Ping is only a cheap pre-filter.
+// the remote service may still be dead. Run Probe afterwards for a full
+// end-to-end check; Ping is only a cheap pre-filter.
 func Ping(host string, port string, timeout time.Duration) bool {
 	return pingTCP(host, port, capTimeout(timeout))
 }
 
-// PingWithScheme dispatches to TCP or UDP fast-fail based on the proxy
-// scheme. Unknown schemes fall back to TCP. scheme matching is
-// case-insensitive and supports the short alias "hy2" alongside
-// "hysteria2".
+// PingWithScheme dispatches to TCP or UDP fast-fail based on the network
+// the scheme's servers listen on, as recorded in DefaultRegistry (UDP for
+// hysteria2 / hy2). Unknown schemes fall back to TCP. scheme matching is
+// case-insensitive.
 //
 // UDP fast-fail: dial the UDP socket, send a 0-byte datagram, then attempt a
 // short read. A successful dial + write implies the port is open; ICMP
@@ -38,12 +38,10 @@ func Ping(host string, port string, timeout time.Duration) bool {
 // still-alive for hysteresis reasons (UDP is unreliable and a single probe
 // is not authoritative).
 func PingWithScheme(host string, port string, scheme string, timeout time.Duration) bool {
-	switch strings.ToLower(strings.TrimSpace(scheme)) {
-	case "hysteria2", "hy2":
+	if Capabilities(strings.TrimSpace(scheme)).Network == "udp" {
 		return pingUDP(host, port, capTimeout(timeout))
-	default:
-		return pingTCP(host, port, capTimeout(timeout))
 	}
+	return pingTCP(host, port, capTimeout(timeout))
 }
 
 // SchemeOfURL extracts the scheme from a raw proxy URL string. It tolerates
@@ -0,0 +1,152 @@
+package proxyclient
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// Backend is one proxy in a Pool.
+type Backend struct {
+	URL string
+
+	transport http.RoundTripper
+	inFlight  atomic.Int64
+}
+
+// InFlight returns the number of requests currently using the backend. A
+// request counts until its response body is closed.
+func (b *Backend) InFlight() int64 {
+	return b.inFlight.Load()
+}
+
+// Pool is an http.RoundTripper that spreads requests over many proxies.
+// Each request is sent through the backend its Strategy picks; connections
+// are pooled per backend, so keep-alive connections stay on the proxy that
+// opened them. A Pool is safe for concurrent use.
+type Pool struct {
+	backends []*Backend
+	strategy Strategy
+}
+
+// NewPool builds a transport for every proxy URL with the scheme's
+// registered ProxyFunc. A nil strategy means RoundRobin.
+func NewPool(proxyURLs []string, strategy Strategy, options ...Option) (*Pool, error) {
+	if len(proxyURLs) == 0 {
+		return nil, ErrEmptyPool
+	}
+
+	if strategy == nil {
+		strategy = RoundRobin()
+	}
+
+	opt := &Options{}
+	for _, o := range options {
+		o(opt)
+	}
+
+	p := &Pool{
+		backends: make([]*Backend, 0, len(proxyURLs)),
+		strategy: strategy,
+	}
+
+	for _, proxyURL := range proxyURLs {
+		tr, err := createProxyTransport(proxyURL, opt)
+		if err != nil {
+			p.Close() //nolint: errcheck
+			return nil, err
+		}
+
+		p.backends = append(p.backends, &Backend{
+			URL:       proxyURL,
+			transport: tr,
+		})
+	}
+
+	return p, nil
+}
+
+// createProxyTransport builds the transport of one backend. ProxyFuncs
+// configure the transport they are given, so a caller supplied transport is
+// cloned rather than shared between backends.
+func createProxyTransport(proxyURL string, opt *Options) (http.RoundTripper, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := opt.registry().LookupProxy(u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	o := *opt
+	if o.Transport != nil {
+		o.Transport = o.Transport.Clone()
+	}
+
+	return f(u, &o)
+}
+
+// Backends returns the backends in the order their URLs were given.
+func (p *Pool) Backends() []*Backend {
+	return p.backends
+}
+
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := p.strategy.Pick(req, p.backends)
+	if b == nil {
+		return nil, ErrEmptyPool
+	}
+
+	b.inFlight.Add(1)
+	resp, err := b.transport.RoundTrip(req)
+	if err != nil {
+		b.inFlight.Add(-1)
+		return nil, err
+	}
+
+	resp.Body = &inFlightBody{ReadCloser: resp.Body, backend: b}
+	return resp, nil
+}
+
+// CloseIdleConnections closes idle connections of every backend.
+func (p *Pool) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+
+	for _, b := range p.backends {
+		if tr, ok := b.transport.(closeIdler); ok {
+			tr.CloseIdleConnections()
+		}
+	}
+}
+
+// Close closes every backend's transport, releasing the resources of
+// protocols such as hysteria2 and the xray schemes. The pool must not be
+// used afterwards.
+func (p *Pool) Close() error {
+	rts := make([]http.RoundTripper, len(p.backends))
+	for i, b := range p.backends {
+		rts[i] = b.transport
+	}
+	return closeAll(rts)
+}
+
+// inFlightBody releases the backend's in-flight slot once the body is
+// closed.
+type inFlightBody struct {
+	io.ReadCloser
+	backend *Backend
+	once    sync.Once
+}
+
+func (b *inFlightBody) Close() error {
+	b.once.Do(func() {
+		b.backend.inFlight.Add(-1)
+	})
+	return b.ReadCloser.Close()
+}
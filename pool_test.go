@@ -0,0 +1,203 @@
+package proxyclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startCountingProxies starts n HTTP proxies and returns their URLs along
+// with the number of requests each one handled.
+func startCountingProxies(t *testing.T, n int) ([]string, []*atomic.Int64) {
+	urls := make([]string, n)
+	counts := make([]*atomic.Int64, n)
+
+	for i := 0; i < n; i++ {
+		count := &atomic.Int64{}
+		proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count.Add(1)
+			if r.Method == http.MethodConnect {
+				handleTunneling(w, r)
+			} else {
+				handleHTTP(w, r)
+			}
+		}))
+		t.Cleanup(proxyServer.Close)
+
+		urls[i] = proxyServer.URL
+		counts[i] = count
+	}
+
+	return urls, counts
+}
+
+func TestPool(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from target server") //nolint: errcheck
+	}))
+	defer targetServer.Close()
+
+	get := func(t *testing.T, client *http.Client, target string) {
+		resp, err := client.Get(target)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint: errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello from target server", string(body))
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		urls, counts := startCountingProxies(t, 3)
+
+		pool, err := NewPool(urls, RoundRobin())
+		require.NoError(t, err)
+
+		client := &http.Client{Transport: pool}
+		for i := 0; i < 9; i++ {
+			get(t, client, targetServer.URL)
+		}
+
+		for i, c := range counts {
+			require.Equal(t, int64(3), c.Load(), "backend %d", i)
+		}
+	})
+
+	t.Run("consistent hash", func(t *testing.T) {
+		urls, counts := startCountingProxies(t, 3)
+
+		pool, err := NewPool(urls, ConsistentHash())
+		require.NoError(t, err)
+
+		client := &http.Client{Transport: pool}
+		for i := 0; i < 6; i++ {
+			get(t, client, targetServer.URL)
+		}
+
+		used := 0
+		for _, c := range counts {
+			if c.Load() > 0 {
+				used++
+				require.Equal(t, int64(6), c.Load())
+			}
+		}
+		require.Equal(t, 1, used, "one host must always map to one backend")
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		urls, counts := startCountingProxies(t, 2)
+
+		pool, err := NewPool(urls, LeastInFlight())
+		require.NoError(t, err)
+
+		client := &http.Client{Transport: pool}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				get(t, client, targetServer.URL)
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, int64(20), counts[0].Load()+counts[1].Load())
+		for _, b := range pool.Backends() {
+			require.Equal(t, int64(0), b.InFlight())
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := NewPool(nil, nil)
+		require.ErrorIs(t, err, ErrEmptyPool)
+	})
+
+	t.Run("unknown scheme", func(t *testing.T) {
+		_, err := NewPool([]string{"unknown://127.0.0.1:1"}, nil)
+		require.ErrorIs(t, err, ErrUnknownProtocol)
+	})
+}
+
+func TestStrategies(t *testing.T) {
+	backends := []*Backend{{URL: "a"}, {URL: "b"}, {URL: "c"}}
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	t.Run("weighted", func(t *testing.T) {
+		s := Weighted(5, 1, 0)
+		picks := map[string]int{}
+		for i := 0; i < 70; i++ {
+			picks[s.Pick(req, backends).URL]++
+		}
+
+		require.Equal(t, map[string]int{"a": 50, "b": 10, "c": 10}, picks)
+	})
+
+	t.Run("least in flight", func(t *testing.T) {
+		s := LeastInFlight()
+
+		backends[0].inFlight.Store(2)
+		backends[1].inFlight.Store(1)
+		backends[2].inFlight.Store(3)
+		defer func() {
+			for _, b := range backends {
+				b.inFlight.Store(0)
+			}
+		}()
+
+		require.Equal(t, "b", s.Pick(req, backends).URL)
+	})
+
+	t.Run("random", func(t *testing.T) {
+		s := Random()
+		for i := 0; i < 10; i++ {
+			require.Contains(t, backends, s.Pick(req, backends))
+		}
+	})
+
+	t.Run("consistent hash", func(t *testing.T) {
+		s := ConsistentHash()
+
+		hosts := map[string]string{}
+		for i := 0; i < 50; i++ {
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://host%d.example.com/", i), nil)
+			hosts[r.URL.Host] = s.Pick(r, backends).URL
+		}
+
+		for host, backend := range hosts {
+			r := httptest.NewRequest(http.MethodGet, "http://"+host+"/path", nil)
+			require.Equal(t, backend, s.Pick(r, backends).URL)
+		}
+	})
+
+	t.Run("changed backends", func(t *testing.T) {
+		others := []*Backend{{URL: "x"}, {URL: "y"}, {URL: "z"}}
+
+		s, fresh := ConsistentHash(), ConsistentHash()
+		for i := 0; i < 50; i++ {
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://host%d.example.com/", i), nil)
+			s.Pick(r, backends)
+			require.Same(t, fresh.Pick(r, others), s.Pick(r, others))
+		}
+
+		w := Weighted(5, 1, 1)
+		w.Pick(req, backends)
+		picks := map[string]int{}
+		for i := 0; i < 7; i++ {
+			picks[w.Pick(req, others).URL]++
+		}
+		require.Equal(t, map[string]int{"x": 5, "y": 1, "z": 1}, picks)
+	})
+
+	t.Run("no backends", func(t *testing.T) {
+		for _, s := range []Strategy{RoundRobin(), Random(), LeastInFlight(), Weighted(), ConsistentHash()} {
+			require.Nil(t, s.Pick(req, nil))
+		}
+	})
+}
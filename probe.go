@@ -0,0 +1,305 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultProbeTarget answers with the caller's IP address in plain text.
+const defaultProbeTarget = "https://api.ipify.org"
+
+// ProbeStage is a step of a Probe, in the order they happen.
+type ProbeStage string
+
+const (
+	// ProbeSetup builds the client for the proxy URL.
+	ProbeSetup ProbeStage = "setup"
+	// ProbeConnect reaches the proxy server.
+	ProbeConnect ProbeStage = "connect"
+	// ProbeHandshake runs the proxy protocol until the target is reached.
+	ProbeHandshake ProbeStage = "handshake"
+	// ProbeTLS is the TLS handshake with an https target.
+	ProbeTLS ProbeStage = "tls"
+	// ProbeResponse sends the request and reads the response.
+	ProbeResponse ProbeStage = "response"
+)
+
+// ProbeError tells at which stage a probe failed.
+type ProbeError struct {
+	Stage ProbeStage
+	Err   error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("proxyclient: probe failed at %s: %v", e.Stage, e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// ProbeResult holds the timings of a Probe. Stages that were not reached
+// are left zero.
+type ProbeResult struct {
+	// Connect is the time taken to reach the proxy server. It is zero for
+	// schemes that cannot report it (hysteria2, xray over kcp or quic) and
+	// when the caller sets its own forward dialer.
+	Connect time.Duration
+	// Handshake is the time from reaching the proxy server to having a
+	// tunnel to the target. vmess, vless, trojan and ssr only talk to
+	// their server once data is sent, so their handshake ends up in TTFB.
+	Handshake time.Duration
+	TLS       time.Duration
+	// TTFB is the time from writing the request to the first response
+	// byte.
+	TTFB  time.Duration
+	Total time.Duration
+
+	StatusCode int
+	// ExitIP is the address the target saw, when it answers with one in
+	// plain text or as the "ip" or "origin" field of a JSON object.
+	ExitIP string
+
+	// Err is a *ProbeError, or nil when the probe succeeded.
+	Err error
+}
+
+// OK reports whether the probe succeeded.
+func (r ProbeResult) OK() bool {
+	return r.Err == nil
+}
+
+// Probe sends a GET for target through proxyURL and measures each step
+// on the way. Unlike Ping it exercises the whole proxy protocol, with the
+// client New builds for proxyURL, so a successful probe means the proxy
+// really works. An empty target uses a service that echoes the exit IP.
+// Whatever the client started for proxyURL is released before Probe
+// returns.
+func Probe(ctx context.Context, proxyURL, target string, options ...Option) *ProbeResult {
+	start := time.Now()
+
+	r := &ProbeResult{}
+	r.Err = probe(ctx, proxyURL, target, options, r)
+	r.Total = time.Since(start)
+
+	return r
+}
+
+// probe fills in r.
+func probe(ctx context.Context, proxyURL, target string, options []Option, r *ProbeResult) error {
+	if target == "" {
+		target = defaultProbeTarget
+	}
+
+	opt := &Options{}
+	for _, o := range options {
+		o(opt)
+	}
+
+	// Time the connection to the proxy server by handing the scheme a
+	// forward dialer, unless the caller has their own. Schemes that cannot
+	// use one (hysteria2, xray over kcp or quic) are probed again without
+	// it.
+	if opt.Forward == nil {
+		timing := &probeDialer{Dialer: net.Dialer{Timeout: opt.Timeout}}
+		err := probeWith(ctx, proxyURL, target, append(options[:len(options):len(options)], WithForward(timing)), timing, r)
+		if !errors.Is(err, ErrChainUnsupported) {
+			return err
+		}
+		*r = ProbeResult{}
+	}
+
+	return probeWith(ctx, proxyURL, target, options, nil, r)
+}
+
+func probeWith(ctx context.Context, proxyURL, target string, options []Option, timing *probeDialer, r *ProbeResult) error {
+	opt := &Options{}
+	for _, o := range options {
+		o(opt)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return &ProbeError{Stage: ProbeSetup, Err: err}
+	}
+
+	// A transport and client of the probe's own, so that nothing is
+	// reused from an earlier request and the caller's client is left
+	// alone.
+	tr := &http.Transport{DisableKeepAlives: true}
+	if opt.Transport != nil && opt.Transport.TLSClientConfig != nil {
+		tr.TLSClientConfig = opt.Transport.TLSClientConfig.Clone()
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	setupStart := time.Now()
+
+	c, err := New(proxyURL, append(options[:len(options):len(options)], WithTransport(tr), WithClient(client))...)
+	if err != nil {
+		return &ProbeError{Stage: ProbeSetup, Err: err}
+	}
+	defer CloseClient(c) //nolint: errcheck
+
+	setup := time.Since(setupStart)
+
+	// The transport runs the dial and the trace hooks on its own
+	// goroutines, which may outlive the request when ctx is cancelled.
+	var mu sync.Mutex
+	var dialTime, tlsTime, ttfb time.Duration
+	var getConn, tlsStart, wrote time.Time
+	var connected bool
+	var tlsErr error
+
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			mu.Lock()
+			getConn = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			dialTime = tlsStart.Sub(getConn)
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mu.Lock()
+			tlsTime, tlsErr = time.Since(tlsStart), err
+			mu.Unlock()
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			mu.Lock()
+			if tlsStart.IsZero() {
+				dialTime = time.Since(getConn)
+			}
+			connected = true
+			mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mu.Lock()
+			wrote = time.Now()
+			mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			ttfb = time.Since(wrote)
+			mu.Unlock()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := c.Do(req)
+
+	mu.Lock()
+	if err != nil && !connected && tlsStart.IsZero() {
+		dialTime = time.Since(getConn)
+	}
+	r.TLS, r.TTFB = tlsTime, ttfb
+	// everything the scheme did to get a tunnel, apart from reaching its
+	// server, counts as handshake
+	r.Handshake = setup + dialTime
+	tlsStarted, tlsFailed := !tlsStart.IsZero(), tlsErr
+	mu.Unlock()
+
+	if timing != nil {
+		connect, connectErr := timing.result()
+		r.Connect = connect
+		r.Handshake = max(0, r.Handshake-connect)
+		if connectErr != nil {
+			return &ProbeError{Stage: ProbeConnect, Err: connectErr}
+		}
+	}
+
+	if err != nil {
+		switch {
+		case tlsFailed != nil:
+			return &ProbeError{Stage: ProbeTLS, Err: tlsFailed}
+		case !connected && !tlsStarted:
+			return &ProbeError{Stage: ProbeHandshake, Err: err}
+		default:
+			return &ProbeError{Stage: ProbeResponse, Err: err}
+		}
+	}
+	defer resp.Body.Close() //nolint: errcheck
+
+	r.StatusCode = resp.StatusCode
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return &ProbeError{Stage: ProbeResponse, Err: err}
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return &ProbeError{Stage: ProbeResponse, Err: fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+
+	r.ExitIP = exitIP(body)
+	return nil
+}
+
+// exitIP picks the IP address out of the response of an IP echo service.
+func exitIP(body []byte) string {
+	if ip := net.ParseIP(strings.TrimSpace(string(body))); ip != nil {
+		return ip.String()
+	}
+
+	var v struct {
+		IP     string `json:"ip"`
+		Origin string `json:"origin"`
+	}
+	if json.Unmarshal(body, &v) != nil {
+		return ""
+	}
+
+	for _, s := range []string{v.IP, v.Origin} {
+		if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+			return ip.String()
+		}
+	}
+
+	return ""
+}
+
+// probeDialer records the first connection a scheme makes to its server.
+type probeDialer struct {
+	net.Dialer
+
+	mu    sync.Mutex
+	start time.Time
+	done  time.Time
+	err   error
+}
+
+func (d *probeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := d.Dialer.DialContext(ctx, network, addr)
+
+	d.mu.Lock()
+	if d.start.IsZero() {
+		d.start, d.done, d.err = start, time.Now(), err
+	}
+	d.mu.Unlock()
+
+	return conn, err
+}
+
+// result returns how long reaching the server took and the error, if any.
+func (d *probeDialer) result() (time.Duration, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.done.Sub(d.start), d.err
+}
@@ -0,0 +1,145 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "203.0.113.7") //nolint: errcheck
+	}))
+	defer plain.Close()
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ip": "2001:db8::7"}`) //nolint: errcheck
+	}))
+	defer secure.Close()
+
+	insecure := WithTransport(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}})
+
+	t.Run("http", func(t *testing.T) {
+		var down atomic.Bool
+		proxy := startFlakyProxy(t, &down)
+
+		r := Probe(context.Background(), proxy, plain.URL)
+		require.NoError(t, r.Err)
+		require.True(t, r.OK())
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		require.Equal(t, "203.0.113.7", r.ExitIP)
+		require.Positive(t, r.Connect)
+		require.Positive(t, r.TTFB)
+		require.Zero(t, r.TLS)
+		require.GreaterOrEqual(t, r.Total, r.Connect+r.TTFB)
+	})
+
+	t.Run("http forwards plain requests", func(t *testing.T) {
+		var methods []string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method)
+			if r.Method == http.MethodConnect {
+				handleTunneling(w, r)
+			} else {
+				handleHTTP(w, r)
+			}
+		}))
+		defer proxy.Close()
+
+		r := Probe(context.Background(), proxy.URL, plain.URL)
+		require.NoError(t, r.Err)
+		require.Equal(t, "203.0.113.7", r.ExitIP)
+		require.Equal(t, []string{http.MethodGet}, methods)
+	})
+
+	t.Run("releases dialers", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks5)
+		defer proxy.Close() //nolint: errcheck
+
+		var dials, closed atomic.Int64
+		reg := NewRegistry()
+		reg.RegisterDialer("closing", func(u *url.URL, o *Options) (ContextDialer, error) {
+			dials.Add(1)
+			d, err := Socks5Dialer(&url.URL{Scheme: "socks5", Host: u.Host}, o)
+			if err != nil {
+				return nil, err
+			}
+			return &closingDialer{Dialer: d.DialContext, closed: &closed}, nil
+		})
+
+		r := Probe(context.Background(), fmt.Sprintf("closing://%s", proxy.Addr()), plain.URL, WithRegistry(reg))
+		require.NoError(t, r.Err)
+		require.Positive(t, dials.Load())
+		require.Equal(t, dials.Load(), closed.Load())
+	})
+
+	t.Run("socks4", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks4)
+		defer proxy.Close() //nolint: errcheck
+
+		r := Probe(context.Background(), fmt.Sprintf("socks4://%s", proxy.Addr()), plain.URL)
+		require.NoError(t, r.Err)
+		require.Positive(t, r.Connect)
+	})
+
+	t.Run("socks5 tls", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks5)
+		defer proxy.Close() //nolint: errcheck
+
+		r := Probe(context.Background(), fmt.Sprintf("socks5://%s", proxy.Addr()), secure.URL, insecure)
+		require.NoError(t, r.Err)
+		require.Equal(t, "2001:db8::7", r.ExitIP)
+		require.Positive(t, r.Connect)
+		require.Positive(t, r.TLS)
+	})
+
+	t.Run("proxy unreachable", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close() //nolint: errcheck
+
+		r := Probe(context.Background(), "socks5://"+addr, plain.URL)
+		var pe *ProbeError
+		require.ErrorAs(t, r.Err, &pe)
+		require.Equal(t, ProbeConnect, pe.Stage)
+		require.False(t, r.OK())
+	})
+
+	t.Run("target unreachable", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks5)
+		defer proxy.Close() //nolint: errcheck
+
+		r := Probe(context.Background(), fmt.Sprintf("socks5://%s", proxy.Addr()), "http://127.0.0.1:1")
+		var pe *ProbeError
+		require.ErrorAs(t, r.Err, &pe)
+		require.Equal(t, ProbeHandshake, pe.Stage)
+		require.Positive(t, r.Connect)
+	})
+
+	t.Run("bad certificate", func(t *testing.T) {
+		proxy := startSocksServer(t, handleSocks5)
+		defer proxy.Close() //nolint: errcheck
+
+		r := Probe(context.Background(), fmt.Sprintf("socks5://%s", proxy.Addr()), secure.URL)
+		var pe *ProbeError
+		require.ErrorAs(t, r.Err, &pe)
+		require.Equal(t, ProbeTLS, pe.Stage)
+	})
+
+	t.Run("unknown scheme", func(t *testing.T) {
+		r := Probe(context.Background(), "unknown://127.0.0.1:1", plain.URL)
+		var pe *ProbeError
+		require.ErrorAs(t, r.Err, &pe)
+		require.Equal(t, ProbeSetup, pe.Stage)
+		require.ErrorIs(t, r.Err, ErrUnknownProtocol)
+	})
+}
@@ -10,12 +10,9 @@ import (
 
 type ProxyFunc func(*url.URL, *Options) (http.RoundTripper, error)
 
-var (
-	supportProxies = make(map[string]ProxyFunc)
-)
-
+// RegisterProxy registers the ProxyFunc of proto in DefaultRegistry.
 func RegisterProxy(proto string, f ProxyFunc) {
-	supportProxies[proto] = f
+	DefaultRegistry.RegisterProxy(proto, f)
 }
 
 func CreateTransport(o *Options) *http.Transport {
@@ -64,10 +61,12 @@ func GetFreePort() (int, error) {
 	return addr.Port, nil
 }
 
+// WithRecover calls dial and turns a panic inside it into an error
+// wrapping ErrDialPanic.
 func WithRecover(dial func() (net.Conn, error)) (conn net.Conn, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			conn, err = nil, fmt.Errorf("net: dial panic: %v", r)
+			conn, err = nil, fmt.Errorf("%w: %v", ErrDialPanic, r)
 		}
 	}()
 	return dial()
@@ -1,18 +1,209 @@
 package proxyclient
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync/atomic"
 )
 
 func init() {
-	supportProxies["http"] = ProxyHTTP
-	supportProxies["https"] = ProxyHTTP
+	RegisterProxy("http", ProxyHTTP)
+	RegisterProxy("https", ProxyHTTP)
+
+	RegisterDialer("http", HTTPDialer)
+	RegisterDialer("https", HTTPDialer)
 }
 
 func ProxyHTTP(u *url.URL, o *Options) (http.RoundTripper, error) {
 	tr := CreateTransport(o)
 	tr.Proxy = http.ProxyURL(u)
 
-	return tr, nil
+	dial := tr.DialContext
+	if o.Forward != nil {
+		dial = o.Forward.DialContext
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	// The transport only ever dials the proxy itself, so an observer sees
+	// the proxy's address and no handshake beyond reaching it.
+	tr.DialContext = o.instrument(Dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrProxyUnreachable, err)
+		}
+		return conn, nil
+	})).DialContext
+
+	tr.OnProxyConnectResponse = func(ctx context.Context, _ *url.URL, req *http.Request, resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return connectError(req.Host, resp)
+		}
+		if tunnel, ok := ctx.Value(proxyTunnelKey{}).(*atomic.Bool); ok {
+			tunnel.Store(true)
+		}
+		return nil
+	}
+
+	// The proxy is the only server the transport talks to: until it has
+	// opened a tunnel, a request that fails without a cause of its own
+	// failed because of the proxy. Past the tunnel, TLS with the target
+	// and the target itself are to blame as much.
+	return &Transport{
+		Transport: tr,
+		roundTrip: func(req *http.Request) (*http.Response, error) {
+			tunnel := new(atomic.Bool)
+			resp, err := tr.RoundTrip(req.WithContext(context.WithValue(req.Context(), proxyTunnelKey{}, tunnel)))
+			if err == nil || tunnel.Load() || req.Context().Err() != nil {
+				return resp, err
+			}
+			return nil, classifyAs(ErrHandshakeFailed, err)
+		},
+	}, nil
+}
+
+// proxyTunnelKey marks the requests of ProxyHTTP, so that the transport
+// can tell when the proxy has opened a tunnel for one.
+type proxyTunnelKey struct{}
+
+// HTTPDialer creates a dialer that tunnels connections through an HTTP(S)
+// proxy with the CONNECT method.
+func HTTPDialer(u *url.URL, o *Options) (ContextDialer, error) {
+	secure := strings.EqualFold(u.Scheme, "https")
+
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		if secure {
+			proxyAddr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	var auth string
+	if u.User != nil {
+		p, _ := u.User.Password()
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+p))
+	}
+
+	var tlsConfig *tls.Config
+	if secure {
+		if o.Transport != nil && o.Transport.TLSClientConfig != nil {
+			tlsConfig = o.Transport.TLSClientConfig.Clone()
+		} else {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+	}
+
+	dialer := o.ForwardDialer()
+
+	return Dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrProxyUnreachable, err)
+		}
+
+		// Abort the handshake as soon as ctx is done; the connection is
+		// handed back to the caller untouched once the tunnel is up.
+		stop := context.AfterFunc(ctx, func() {
+			conn.Close() //nolint: errcheck
+		})
+
+		conn, err = connectTunnel(ctx, conn, tlsConfig, addr, auth)
+		if !stop() {
+			if conn != nil {
+				conn.Close() //nolint: errcheck
+			}
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return conn, nil
+	}), nil
+}
+
+// connectTunnel performs the CONNECT handshake on conn. conn is closed on
+// any failure.
+func connectTunnel(ctx context.Context, conn net.Conn, tlsConfig *tls.Config, addr, auth string) (net.Conn, error) {
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close() //nolint: errcheck
+			return nil, fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if auth != "" {
+		req.Header.Set("Proxy-Authorization", auth)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close() //nolint: errcheck
+		return nil, fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close() //nolint: errcheck
+		return nil, fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+
+	// The body is deliberately left unread: after a successful CONNECT
+	// everything behind the header belongs to the tunnel.
+	if resp.StatusCode != http.StatusOK {
+		conn.Close() //nolint: errcheck
+		return nil, connectError(addr, resp)
+	}
+
+	// The proxy may have pipelined the first bytes of the tunnel right
+	// behind its response; keep them.
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// connectError classifies a CONNECT request the proxy turned down by its
+// status code.
+func connectError(addr string, resp *http.Response) error {
+	kind := ErrHandshakeFailed
+	switch resp.StatusCode {
+	case http.StatusProxyAuthRequired:
+		kind = ErrProxyAuthFailed
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		kind = ErrTargetUnreachable
+	}
+
+	return fmt.Errorf("%w: CONNECT %s: %s", kind, addr, resp.Status)
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
 }
@@ -5,48 +5,57 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
-	"time"
-
-	"golang.org/x/net/proxy"
-	"h12.io/socks"
+	"strings"
 )
 
 func init() {
-	supportProxies["socks5"] = ProxySocks5
-	supportProxies["socks5h"] = ProxySocks5
-	supportProxies["socks4"] = ProxySocks4
-	supportProxies["socks4a"] = ProxySocks4
-
+	RegisterProxy("socks5", ProxySocks5)
+	RegisterProxy("socks5h", ProxySocks5)
+	RegisterProxy("socks4", ProxySocks4)
+	RegisterProxy("socks4a", ProxySocks4)
+
+	RegisterDialer("socks5", Socks5Dialer)
+	RegisterDialer("socks5h", Socks5Dialer)
+	RegisterDialer("socks4", Socks4Dialer)
+	RegisterDialer("socks4a", Socks4Dialer)
 }
 
-func ProxySocks5(u *url.URL, o *Options) (http.RoundTripper, error) {
-	tr := CreateTransport(o)
-
-	dialer := &net.Dialer{}
-
-	if o.Timeout > 0 {
-		dialer.Timeout = o.Timeout
+// Socks5Dialer creates a dialer that connects through a SOCKS5 proxy. The
+// returned *Socks5Client also relays UDP and accepts connections with
+// BIND.
+func Socks5Dialer(u *url.URL, o *Options) (ContextDialer, error) {
+	port := u.Port()
+	if port == "" {
+		port = "1080"
 	}
 
-	var auth *proxy.Auth
+	c := &Socks5Client{
+		server:        net.JoinHostPort(u.Hostname(), port),
+		forward:       o.ForwardDialer(),
+		packetForward: o.Forward,
+	}
 	if u.User != nil {
-		auth = new(proxy.Auth)
-		auth.User = u.User.Username()
-		if p, ok := u.User.Password(); ok {
-			auth.Password = p
-		}
+		c.auth = true
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
 	}
 
-	d, err := proxy.SOCKS5("tcp", net.JoinHostPort(u.Hostname(), u.Port()), auth, dialer)
+	return c, nil
+}
+
+func ProxySocks5(u *url.URL, o *Options) (http.RoundTripper, error) {
+	d, err := Socks5Dialer(u, o)
 	if err != nil {
 		return nil, err
 	}
+	d = o.instrument(d)
 
-	xd := d.(proxy.ContextDialer)
-	tr.DialContext = xd.DialContext
+	tr := CreateTransport(o)
+	tr.DialContext = d.DialContext
 	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		return dialTLSContext(ctx, xd.DialContext, network, addr, tr.TLSClientConfig)
+		return dialTLSContext(ctx, d.DialContext, network, addr, tr.TLSClientConfig)
 	}
 
 	tr.Proxy = nil
@@ -54,30 +63,40 @@ func ProxySocks5(u *url.URL, o *Options) (http.RoundTripper, error) {
 	return tr, nil
 }
 
-func ProxySocks4(u *url.URL, o *Options) (http.RoundTripper, error) {
-	tr := CreateTransport(o)
-
-	proxyURL := u.String()
+// Socks4Dialer creates a dialer that connects through a SOCKS4/4a proxy.
+// socks4 resolves target host names itself, with o.Resolver if set;
+// socks4a leaves them to the proxy. The user of the URL is sent as the
+// user ID.
+func Socks4Dialer(u *url.URL, o *Options) (ContextDialer, error) {
+	port := u.Port()
+	if port == "" {
+		port = "1080"
+	}
 
-	if o.Timeout > 0 {
-		proxyURL += "?timeout=" + o.Timeout.String()
+	c := &socks4Client{
+		server:    net.JoinHostPort(u.Hostname(), port),
+		remoteDNS: strings.EqualFold(u.Scheme, "socks4a"),
+		forward:   o.ForwardDialer(),
+		resolver:  o.Resolver,
 	}
+	if u.User != nil {
+		c.userID = u.User.Username()
+	}
+
+	return c, nil
+}
 
-	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		return socks.Dial(proxyURL)(network, addr)
+func ProxySocks4(u *url.URL, o *Options) (http.RoundTripper, error) {
+	d, err := Socks4Dialer(u, o)
+	if err != nil {
+		return nil, err
 	}
-	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		conn, err := dialTLSContext(ctx, tr.DialContext, network, addr, tr.TLSClientConfig)
-		if err != nil {
-			return nil, err
-		}
+	d = o.instrument(d)
 
-		err = conn.SetDeadline(time.Now().Add(o.Timeout))
-		if err != nil {
-			conn.Close() //nolint: errcheck
-			return nil, err
-		}
-		return conn, nil
+	tr := CreateTransport(o)
+	tr.DialContext = d.DialContext
+	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialTLSContext(ctx, d.DialContext, network, addr, tr.TLSClientConfig)
 	}
 
 	tr.Proxy = nil
@@ -85,8 +104,6 @@ func ProxySocks4(u *url.URL, o *Options) (http.RoundTripper, error) {
 	return tr, nil
 }
 
-type Dialer func(ctx context.Context, network string, address string) (net.Conn, error)
-
 func dialTLSContext(ctx context.Context, dialer Dialer, network, addr string, tlsConfig *tls.Config) (net.Conn, error) {
 	conn, err := dialer(ctx, network, addr)
 	if err != nil {
@@ -105,8 +122,19 @@ func dialTLSContext(ctx context.Context, dialer Dialer, network, addr string, tl
 	tlsConfig = tlsConfig.Clone()
 	tlsConfig.ServerName = host
 
+	// The transport leaves the TLS hooks of a client trace to custom TLS
+	// dialers.
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+
 	tlsConn := tls.Client(conn, tlsConfig)
-	if err := tlsConn.HandshakeContext(ctx); err != nil {
+	err = tlsConn.HandshakeContext(ctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+	}
+	if err != nil {
 		tlsConn.Close() //nolint: errcheck
 		return nil, err
 	}
@@ -0,0 +1,140 @@
+package proxyclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+)
+
+// socks5UDPHeaderMax is the largest header in front of a relayed datagram:
+// RSV RSV FRAG ATYP, a 255 byte host name with its length and the port.
+const socks5UDPHeaderMax = 4 + 1 + 255 + 2
+
+// ListenPacket opens a UDP ASSOCIATE session (RFC 1928, section 7) on the
+// server.
+func (c *Socks5Client) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	if err := checkUDPNetwork(network); err != nil {
+		return nil, err
+	}
+
+	// The datagrams go to the relay directly, not over the TCP
+	// connection, so a forward dialer must be able to carry them too.
+	var forward PacketDialer
+	if c.packetForward != nil {
+		pd, ok := c.packetForward.(PacketDialer)
+		if !ok {
+			return nil, fmt.Errorf("socks5 UDP needs a UDP capable forward dialer: %w", ErrChainUnsupported)
+		}
+		forward = pd
+	}
+
+	ctrl, err := c.dialServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the client does not know which address it will send from yet
+	relay, err := c.request(ctx, ctrl, socks5CmdUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		ctrl.Close() //nolint: errcheck
+		return nil, err
+	}
+	relay = c.serverAddr(relay)
+
+	pc := &socks5PacketConn{ctrl: ctrl}
+	if forward != nil {
+		pc.relay = packetAddr(relay)
+		pc.PacketConn, err = forward.ListenPacket(ctx, network)
+	} else {
+		var ua *net.UDPAddr
+		if ua, err = net.ResolveUDPAddr(network, relay); err == nil {
+			pc.relay = ua
+			var lc net.ListenConfig
+			pc.PacketConn, err = lc.ListenPacket(ctx, network, ":0")
+		}
+	}
+	if err != nil {
+		ctrl.Close() //nolint: errcheck
+		return nil, err
+	}
+
+	go pc.watch()
+
+	return pc, nil
+}
+
+// socks5PacketConn wraps each datagram in the SOCKS5 UDP request header
+// and sends it to the relay. The association lasts as long as the TCP
+// control connection.
+type socks5PacketConn struct {
+	net.PacketConn
+	ctrl  net.Conn
+	relay net.Addr
+}
+
+// watch closes the conn once the server drops the control connection.
+func (c *socks5PacketConn) watch() {
+	io.Copy(io.Discard, c.ctrl) //nolint: errcheck
+	c.Close()                   //nolint: errcheck
+}
+
+func (c *socks5PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	// RSV RSV FRAG
+	b := make([]byte, 3, socks5UDPHeaderMax+len(p))
+	b, err := appendSocks5Addr(b, addr.String())
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := c.PacketConn.WriteTo(append(b, p...), c.relay); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *socks5PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, socks5UDPHeaderMax+len(p))
+
+	for {
+		n, from, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if !c.fromRelay(from) {
+			continue
+		}
+
+		// fragments are dropped, as RFC 1928 allows for clients that do
+		// not reassemble
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+
+		r := bytes.NewReader(buf[4:n])
+		addr, err := readSocks5Addr(r, buf[3])
+		if err != nil {
+			continue
+		}
+
+		return copy(p, buf[n-r.Len():n]), packetAddr(addr), nil
+	}
+}
+
+func (c *socks5PacketConn) fromRelay(from net.Addr) bool {
+	relay, ok := c.relay.(*net.UDPAddr)
+	if !ok {
+		return true
+	}
+
+	ua, ok := from.(*net.UDPAddr)
+	return !ok || (ua.IP.Equal(relay.IP) && ua.Port == relay.Port)
+}
+
+func (c *socks5PacketConn) Close() error {
+	c.ctrl.Close() //nolint: errcheck
+	return c.PacketConn.Close()
+}
@@ -1,10 +1,10 @@
 package proxyclient
 
 import (
-	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -78,7 +78,7 @@ func handleSocks4(conn net.Conn, t *testing.T) {
 	// }
 
 	// Connect to target
-	target := fmt.Sprintf("%s:%d", ip.String(), port)
+	target := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
 	targetConn, err := net.DialTimeout("tcp", target, 10*time.Second)
 	if err != nil {
 		// Send failure response
@@ -193,7 +193,7 @@ func handleSocks5(conn net.Conn, t *testing.T) {
 		return
 	}
 
-	targetConn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), 10*time.Second)
+	targetConn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))), 10*time.Second)
 	if err != nil {
 		// Host unreachable
 		conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0}) // nolint: errcheck
@@ -0,0 +1,179 @@
+package proxyclient
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit caps the bandwidth of the client at up bytes per second
+// sent and down bytes per second received. The limits are token buckets
+// shared by all connections of the client, whatever their scheme. A limit
+// of zero or less leaves that direction unlimited.
+func WithRateLimit(up, down int64) Option {
+	return func(o *Options) {
+		o.Upload = newLimiter(up)
+		o.Download = newLimiter(down)
+	}
+}
+
+// newLimiter returns a limiter that lets through limit bytes per second,
+// with a burst of one second's worth.
+func newLimiter(limit int64) *rate.Limiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	return rate.NewLimiter(rate.Limit(limit), int(limit))
+}
+
+// limitDialer returns a dialer whose connections, and packet connections
+// if it relays UDP, are throttled by o.Upload and o.Download, or d itself
+// when neither is set.
+func limitDialer(o *Options, d ContextDialer) ContextDialer {
+	up, down := o.Upload, o.Download
+	if up == nil && down == nil {
+		return d
+	}
+
+	listen := func(pd PacketDialer) PacketDialer {
+		return ListenPacketFunc(func(ctx context.Context, network string) (net.PacketConn, error) {
+			pc, err := pd.ListenPacket(ctx, network)
+			if err != nil {
+				return nil, err
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			return &limitedPacketConn{
+				PacketConn: pc,
+				up:         up,
+				down:       down,
+				ctx:        ctx,
+				cancel:     cancel,
+			}, nil
+		})
+	}
+
+	return wrapDialer(d, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		return &limitedConn{
+			Conn:   conn,
+			up:     up,
+			down:   down,
+			ctx:    ctx,
+			cancel: cancel,
+		}, nil
+	}, listen)
+}
+
+// limitedConn throttles a connection. ctx is cancelled on Close, so a
+// goroutine waiting for tokens is released when the connection goes away.
+type limitedConn struct {
+	net.Conn
+	up     *rate.Limiter
+	down   *rate.Limiter
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Read takes tokens for what it has read, so a fast sender is slowed down
+// by TCP flow control once the client stops reading.
+func (c *limitedConn) Read(b []byte) (int, error) {
+	if c.down == nil {
+		return c.Conn.Read(b)
+	}
+
+	if burst := c.down.Burst(); len(b) > burst {
+		b = b[:burst]
+	}
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if werr := c.down.WaitN(c.ctx, n); werr != nil && err == nil {
+			err = net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+// Write takes tokens before each chunk it writes, no chunk being larger
+// than the burst of the limiter.
+func (c *limitedConn) Write(b []byte) (int, error) {
+	if c.up == nil {
+		return c.Conn.Write(b)
+	}
+
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if burst := c.up.Burst(); len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+
+		if err := c.up.WaitN(c.ctx, len(chunk)); err != nil {
+			return written, net.ErrClosed
+		}
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+
+	return written, nil
+}
+
+func (c *limitedConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+// limitedPacketConn throttles a packet connection. Datagrams cannot be
+// split, so one larger than the burst takes the whole burst.
+type limitedPacketConn struct {
+	net.PacketConn
+	up     *rate.Limiter
+	down   *rate.Limiter
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *limitedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if n > 0 && c.down != nil {
+		if werr := waitDatagram(c.ctx, c.down, n); werr != nil && err == nil {
+			err = net.ErrClosed
+		}
+	}
+	return n, addr, err
+}
+
+func (c *limitedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.up != nil {
+		if err := waitDatagram(c.ctx, c.up, len(b)); err != nil {
+			return 0, net.ErrClosed
+		}
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *limitedPacketConn) Close() error {
+	c.cancel()
+	return c.PacketConn.Close()
+}
+
+// waitDatagram takes the tokens for a datagram of n bytes from l.
+func waitDatagram(ctx context.Context, l *rate.Limiter, n int) error {
+	if burst := l.Burst(); n > burst {
+		n = burst
+	}
+	return l.WaitN(ctx, n)
+}
@@ -0,0 +1,132 @@
+package proxyclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	const limit = 64 << 10
+
+	payload := bytes.Repeat([]byte("x"), 2*limit)
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload) //nolint: errcheck
+	}))
+	defer targetServer.Close()
+
+	proxy := startSocksServer(t, handleSocks5)
+	defer proxy.Close() //nolint: errcheck
+
+	t.Run("download", func(t *testing.T) {
+		c, err := New("socks5://"+proxy.Addr().String(), WithRateLimit(0, limit))
+		require.NoError(t, err)
+
+		start := time.Now()
+		resp, err := c.Get(targetServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint: errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, payload, body)
+
+		// the first second's worth is the burst, the rest takes a second
+		require.GreaterOrEqual(t, time.Since(start), 800*time.Millisecond)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		echo := startEchoServer(t)
+		defer echo.Close() //nolint: errcheck
+
+		d := limitDialer(&Options{Upload: newLimiter(limit)}, &net.Dialer{})
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close() //nolint: errcheck
+
+		go io.Copy(io.Discard, conn) //nolint: errcheck
+
+		start := time.Now()
+		n, err := conn.Write(payload)
+		require.NoError(t, err)
+		require.Equal(t, len(payload), n)
+		require.GreaterOrEqual(t, time.Since(start), 800*time.Millisecond)
+	})
+
+	t.Run("close releases writer", func(t *testing.T) {
+		echo := startEchoServer(t)
+		defer echo.Close() //nolint: errcheck
+
+		d := limitDialer(&Options{Upload: newLimiter(1)}, &net.Dialer{})
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+
+		time.AfterFunc(100*time.Millisecond, func() {
+			conn.Close() //nolint: errcheck
+		})
+
+		_, err = conn.Write(payload)
+		require.ErrorIs(t, err, net.ErrClosed)
+	})
+
+	t.Run("dialer", func(t *testing.T) {
+		echo := startEchoServer(t)
+		defer echo.Close() //nolint: errcheck
+
+		d, err := NewDialer("socks5://"+proxy.Addr().String(), WithRateLimit(limit, 0))
+		require.NoError(t, err)
+		require.Implements(t, (*PacketDialer)(nil), d)
+		require.Implements(t, (*BindDialer)(nil), d)
+
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close() //nolint: errcheck
+
+		go io.Copy(io.Discard, conn) //nolint: errcheck
+
+		start := time.Now()
+		_, err = conn.Write(payload)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), 800*time.Millisecond)
+	})
+
+	t.Run("packet", func(t *testing.T) {
+		const limit = 16 << 10
+
+		sink, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer sink.Close() //nolint: errcheck
+
+		var d ContextDialer = UDPDialer{
+			Dialer: (&net.Dialer{}).DialContext,
+			ListenPacketFunc: func(ctx context.Context, network string) (net.PacketConn, error) {
+				return net.ListenPacket(network, "127.0.0.1:0")
+			},
+		}
+		d = limitDialer(&Options{Upload: newLimiter(limit)}, d)
+
+		pc, err := d.(PacketDialer).ListenPacket(context.Background(), "udp")
+		require.NoError(t, err)
+		defer pc.Close() //nolint: errcheck
+
+		datagram := make([]byte, limit)
+		start := time.Now()
+		for range 2 {
+			_, err := pc.WriteTo(datagram, sink.LocalAddr())
+			require.NoError(t, err)
+		}
+		require.GreaterOrEqual(t, time.Since(start), 800*time.Millisecond)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		d := &net.Dialer{}
+		require.Same(t, d, limitDialer(&Options{}, d))
+	})
+}
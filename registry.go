@@ -0,0 +1,205 @@
+package proxyclient
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry maps URL schemes to the functions that handle them: a ProxyFunc
+// for New, a DialerFunc for NewDialer and a FuncParser for ParseURL, along
+// with what the scheme can do and how it converts to and from Clash proxy
+// entries. Schemes are matched case-insensitively. A Registry is safe for
+// concurrent use, so schemes can be registered while clients are being
+// built.
+type Registry struct {
+	mu      sync.RWMutex
+	proxies map[string]ProxyFunc
+	dialers map[string]DialerFunc
+	parsers map[string]FuncParser
+
+	capabilities map[string]SchemeCapabilities
+
+	clashParsers    map[string]ClashParser
+	clashMarshalers map[string]ClashMarshaler
+}
+
+// DefaultRegistry holds the schemes registered by this package and its
+// subpackages. RegisterProxy, RegisterDialer and RegisterParser add to it,
+// and it is used by every client built without WithRegistry.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		proxies: make(map[string]ProxyFunc),
+		dialers: make(map[string]DialerFunc),
+		parsers: make(map[string]FuncParser),
+
+		capabilities: make(map[string]SchemeCapabilities),
+
+		clashParsers:    make(map[string]ClashParser),
+		clashMarshalers: make(map[string]ClashMarshaler),
+	}
+}
+
+// WithRegistry makes the client look its scheme up in r instead of
+// DefaultRegistry.
+func WithRegistry(r *Registry) Option {
+	return func(o *Options) {
+		o.Registry = r
+	}
+}
+
+// registry returns the registry the options select.
+func (o *Options) registry() *Registry {
+	if o.Registry != nil {
+		return o.Registry
+	}
+	return DefaultRegistry
+}
+
+// Clone returns a registry with the same schemes as r. Changes to either
+// do not affect the other, so a clone of DefaultRegistry is a sandbox in
+// which built-in schemes can be overridden or removed.
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c := NewRegistry()
+	for proto, f := range r.proxies {
+		c.proxies[proto] = f
+	}
+	for proto, f := range r.dialers {
+		c.dialers[proto] = f
+	}
+	for proto, f := range r.parsers {
+		c.parsers[proto] = f
+	}
+	for proto, caps := range r.capabilities {
+		c.capabilities[proto] = caps
+	}
+	for typ, f := range r.clashParsers {
+		c.clashParsers[typ] = f
+	}
+	for proto, f := range r.clashMarshalers {
+		c.clashMarshalers[proto] = f
+	}
+	return c
+}
+
+// RegisterProxy registers the ProxyFunc of proto, replacing any previous one.
+func (r *Registry) RegisterProxy(proto string, f ProxyFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.proxies[strings.ToLower(proto)] = f
+}
+
+// RegisterDialer registers the DialerFunc of proto, replacing any previous
+// one.
+func (r *Registry) RegisterDialer(proto string, f DialerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dialers[strings.ToLower(proto)] = f
+}
+
+// RegisterParser registers the FuncParser of proto, replacing any previous
+// one.
+func (r *Registry) RegisterParser(proto string, f FuncParser) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.parsers[strings.ToLower(proto)] = f
+}
+
+// Unregister removes everything registered for proto, including the Clash
+// parser of the Clash type of the same name.
+func (r *Registry) Unregister(proto string) {
+	proto = strings.ToLower(proto)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.proxies, proto)
+	delete(r.dialers, proto)
+	delete(r.parsers, proto)
+	delete(r.capabilities, proto)
+	delete(r.clashParsers, proto)
+	delete(r.clashMarshalers, proto)
+}
+
+// LookupProxy returns the ProxyFunc for scheme, falling back to one built
+// from its registered dialer.
+func (r *Registry) LookupProxy(scheme string) (ProxyFunc, error) {
+	scheme = strings.ToLower(scheme)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if f, ok := r.proxies[scheme]; ok {
+		return f, nil
+	}
+
+	if df, ok := r.dialers[scheme]; ok {
+		return dialerProxy(df), nil
+	}
+
+	return nil, ErrUnknownProtocol
+}
+
+// LookupDialer returns the DialerFunc for scheme.
+func (r *Registry) LookupDialer(scheme string) (DialerFunc, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.dialers[strings.ToLower(scheme)]
+	if !ok {
+		return nil, ErrUnknownProtocol
+	}
+	return f, nil
+}
+
+// LookupParser returns the FuncParser for scheme, if there is one.
+func (r *Registry) LookupParser(scheme string) (FuncParser, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.parsers[strings.ToLower(scheme)]
+	return f, ok
+}
+
+// Schemes returns every scheme that New can build a client for, sorted.
+func (r *Registry) Schemes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	schemes := make([]string, 0, len(r.proxies))
+	for proto := range r.proxies {
+		schemes = append(schemes, proto)
+	}
+	for proto := range r.dialers {
+		if _, ok := r.proxies[proto]; !ok {
+			schemes = append(schemes, proto)
+		}
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// ParseURL parses u with the parser registered for its scheme, or as a
+// plain proxy URL when there is none.
+func (r *Registry) ParseURL(u string) (URL, error) {
+	parsedURL, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if parser, ok := r.LookupParser(parsedURL.Scheme); ok {
+		return parser(parsedURL)
+	}
+
+	if IsHost(parsedURL.Hostname()) {
+		return &stdURL{*parsedURL}, nil
+	}
+
+	return nil, ErrInvalidHost
+}
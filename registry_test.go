@@ -0,0 +1,122 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from target server") //nolint: errcheck
+	}))
+	defer targetServer.Close()
+
+	direct := func(u *url.URL, o *Options) (ContextDialer, error) {
+		return &net.Dialer{}, nil
+	}
+
+	t.Run("sandbox", func(t *testing.T) {
+		r := DefaultRegistry.Clone()
+		r.RegisterDialer("In-House", direct)
+		r.Unregister("socks5")
+
+		_, err := New("in-house://127.0.0.1:1")
+		require.ErrorIs(t, err, ErrUnknownProtocol)
+
+		c, err := New("in-house://127.0.0.1:1", WithRegistry(r))
+		require.NoError(t, err)
+		resp, err := c.Get(targetServer.URL)
+		require.NoError(t, err)
+		resp.Body.Close() //nolint: errcheck
+
+		_, err = NewDialer("socks5://127.0.0.1:1", WithRegistry(r))
+		require.ErrorIs(t, err, ErrUnknownProtocol)
+
+		_, err = NewDialer("socks5://127.0.0.1:1")
+		require.NoError(t, err)
+		require.Contains(t, r.Schemes(), "in-house")
+	})
+
+	t.Run("parser", func(t *testing.T) {
+		r := NewRegistry()
+		r.RegisterParser("custom", func(u *url.URL) (URL, error) {
+			return nil, ErrInvalidHost
+		})
+
+		_, err := r.ParseURL("custom://example.com:1")
+		require.ErrorIs(t, err, ErrInvalidHost)
+
+		u, err := r.ParseURL("socks5://example.com:1")
+		require.NoError(t, err)
+		require.Equal(t, "example.com", u.Host())
+	})
+
+	t.Run("clash", func(t *testing.T) {
+		entry := map[string]any{"type": "socks5", "server": "example.com", "port": 1080}
+
+		_, err := NewRegistry().ParseClashProxy(entry)
+		require.ErrorIs(t, err, ErrUnsupportedClashType)
+
+		r := DefaultRegistry.Clone()
+		u, err := r.ParseClashProxy(entry)
+		require.NoError(t, err)
+		require.Equal(t, "example.com", u.Host())
+
+		r.Unregister("socks5")
+		_, err = r.ParseClashProxy(entry)
+		require.ErrorIs(t, err, ErrUnsupportedClashType)
+		_, err = r.MarshalClashProxy(u)
+		require.ErrorIs(t, err, ErrUnsupportedClashType)
+
+		_, err = ParseClashProxy(entry)
+		require.NoError(t, err)
+	})
+
+	t.Run("subscription", func(t *testing.T) {
+		r := NewRegistry()
+		r.RegisterParser("custom", func(u *url.URL) (URL, error) {
+			return nil, ErrInvalidHost
+		})
+
+		urls, errs := r.ParseSubscription(strings.NewReader("custom://example.com:1\nsocks5://example.com:1080\n"))
+		require.Len(t, urls, 1)
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs[0], ErrInvalidHost)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		r := NewRegistry()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				r.RegisterDialer(fmt.Sprintf("scheme%d", i), direct)
+			}()
+			go func() {
+				defer wg.Done()
+				d, err := NewDialer("scheme0://127.0.0.1:1", WithRegistry(r))
+				if err != nil {
+					return
+				}
+				conn, err := d.DialContext(context.Background(), "tcp", targetServer.Listener.Addr().String())
+				if err == nil {
+					conn.Close() //nolint: errcheck
+				}
+			}()
+		}
+		wg.Wait()
+
+		require.Len(t, r.Schemes(), 8)
+	})
+}
@@ -0,0 +1,246 @@
+package proxyclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultDNSServer is the server NewResolver queries when none is given.
+const DefaultDNSServer = "tcp://1.1.1.1:53"
+
+// WithResolver makes dialers that would otherwise resolve target host names
+// locally, such as socks4, resolve them with r. Pair it with NewResolver to
+// keep lookups off the local network.
+func WithResolver(r *net.Resolver) Option {
+	return func(o *Options) {
+		o.Resolver = r
+	}
+}
+
+// NewResolver returns a resolver whose every query travels through
+// proxyURL, so that no lookup ever reaches the local network. server picks
+// the DNS server and the transport:
+//
+//	tcp://1.1.1.1:53                     DNS over TCP (also plain host:port)
+//	tls://1.1.1.1:853                    DNS over TLS
+//	https://cloudflare-dns.com/dns-query DNS over HTTPS
+//
+// An empty server means DefaultDNSServer. The ports default to 53 and 853.
+// The io.Closer releases the proxy dialer or client behind the resolver; it
+// must not be used afterwards.
+func NewResolver(proxyURL, server string, options ...Option) (*net.Resolver, io.Closer, error) {
+	if server == "" {
+		server = DefaultDNSServer
+	}
+	if !strings.Contains(server, "://") {
+		server = "tcp://" + server
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: dns server %q: %w", ErrConfigUnsupported, server, err)
+	}
+	if u.Hostname() == "" {
+		return nil, nil, fmt.Errorf("%w: dns server %q has no host", ErrConfigUnsupported, server)
+	}
+
+	var (
+		dial   Dialer
+		closer io.Closer
+	)
+	switch strings.ToLower(u.Scheme) {
+	case "tcp", "tls":
+		d, err := NewDialer(proxyURL, options...)
+		if err != nil {
+			return nil, nil, err
+		}
+		dial = streamDNS(d, u)
+		closer = closerFunc(func() error { return closeDialer(d) })
+	case "https", "http":
+		c, err := New(proxyURL, options...)
+		if err != nil {
+			return nil, nil, err
+		}
+		dial = httpsDNS(c, u.String())
+		closer = closerFunc(func() error { return CloseClient(c) })
+	default:
+		return nil, nil, fmt.Errorf("%w: dns server scheme %q", ErrConfigUnsupported, u.Scheme)
+	}
+
+	// The resolver asks for the local name servers; dial ignores them in
+	// favour of server.
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     dial,
+	}, closer, nil
+}
+
+// streamDNS dials DNS over TCP, or over TLS for a tls:// server, through d.
+// The resolver speaks TCP framing on any connection that is no PacketConn.
+func streamDNS(d ContextDialer, u *url.URL) Dialer {
+	secure := strings.EqualFold(u.Scheme, "tls")
+
+	addr := u.Host
+	if u.Port() == "" {
+		port := "53"
+		if secure {
+			port = "853"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if !secure {
+			return conn, nil
+		}
+
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close() //nolint: errcheck
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// httpsDNS returns connections that carry DNS over HTTPS (RFC 8484) to
+// endpoint with c.
+func httpsDNS(c *http.Client, endpoint string) Dialer {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return &dohConn{ctx: ctx, client: c, endpoint: endpoint}, nil
+	}
+}
+
+// dohConn looks like a DNS over TCP connection to the resolver: every
+// length-prefixed query written to it is posted to the endpoint and the
+// answer is read back with the same framing.
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	endpoint string
+	deadline time.Time
+	wbuf     bytes.Buffer
+	rbuf     bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.wbuf.Write(b)
+
+	for c.wbuf.Len() >= 2 {
+		size := int(binary.BigEndian.Uint16(c.wbuf.Bytes()))
+		if c.wbuf.Len() < 2+size {
+			break
+		}
+
+		c.wbuf.Next(2)
+		answer, err := c.exchange(c.wbuf.Next(size))
+		if err != nil {
+			return 0, err
+		}
+
+		c.rbuf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+		c.rbuf.Write(answer)
+	}
+
+	return len(b), nil
+}
+
+func (c *dohConn) exchange(query []byte) ([]byte, error) {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns: %s: %s", c.endpoint, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 0xFFFF))
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.rbuf.Read(b)
+}
+
+func (c *dohConn) Close() error {
+	return nil
+}
+
+func (c *dohConn) LocalAddr() net.Addr {
+	return dohAddr{}
+}
+
+func (c *dohConn) RemoteAddr() net.Addr {
+	return dohAddr{}
+}
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "https" }
+func (dohAddr) String() string  { return "doh" }
+
+// resolveTarget resolves the host of addr to an IPv4 address with r, for
+// protocols that can only carry one.
+func resolveTarget(ctx context.Context, r *net.Resolver, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	ips, err := r.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrTargetUnreachable, err)
+	}
+
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
@@ -0,0 +1,144 @@
+package proxyclient
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// answerDNS answers every A question in query with 127.0.0.1 and leaves
+// any other question unanswered.
+func answerDNS(t *testing.T, query []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	require.NoError(t, err)
+	q, err := p.Question()
+	require.NoError(t, err)
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(q))
+	require.NoError(t, b.StartAnswers())
+	if q.Type == dnsmessage.TypeA {
+		err = b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+		require.NoError(t, err)
+	}
+
+	answer, err := b.Finish()
+	require.NoError(t, err)
+	return answer
+}
+
+// startDNSServer starts a DNS over TCP server that answers with answerDNS.
+func startDNSServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() //nolint: errcheck
+				for {
+					var size [2]byte
+					if _, err := io.ReadFull(conn, size[:]); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(size[:]))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+
+					answer := answerDNS(t, query)
+					conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer)))) //nolint: errcheck
+					conn.Write(answer)                                                  //nolint: errcheck
+				}
+			}()
+		}
+	}()
+
+	return listener
+}
+
+func TestNewResolver(t *testing.T) {
+	dnsServer := startDNSServer(t)
+	defer dnsServer.Close() //nolint: errcheck
+
+	dohServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/dns-message", r.Header.Get("Content-Type"))
+		query, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answerDNS(t, query)) //nolint: errcheck
+	}))
+	defer dohServer.Close()
+
+	var proxied atomic.Int64
+	proxy := startSocksServer(t, func(conn net.Conn, t *testing.T) {
+		proxied.Add(1)
+		handleSocks5(conn, t)
+	})
+	defer proxy.Close() //nolint: errcheck
+	proxyURL := "socks5://" + proxy.Addr().String()
+
+	t.Run("tcp", func(t *testing.T) {
+		proxied.Store(0)
+		r, closer, err := NewResolver(proxyURL, dnsServer.Addr().String())
+		require.NoError(t, err)
+		defer closer.Close() //nolint: errcheck
+
+		ips, err := r.LookupIP(context.Background(), "ip4", "example.test")
+		require.NoError(t, err)
+		require.Equal(t, "127.0.0.1", ips[0].String())
+		require.Positive(t, proxied.Load())
+	})
+
+	t.Run("https", func(t *testing.T) {
+		proxied.Store(0)
+		r, closer, err := NewResolver(proxyURL, dohServer.URL+"/dns-query")
+		require.NoError(t, err)
+		defer closer.Close() //nolint: errcheck
+
+		ips, err := r.LookupIP(context.Background(), "ip4", "example.test")
+		require.NoError(t, err)
+		require.Equal(t, "127.0.0.1", ips[0].String())
+		require.Positive(t, proxied.Load())
+	})
+
+	t.Run("socks4", func(t *testing.T) {
+		echo := startEchoServer(t)
+		defer echo.Close() //nolint: errcheck
+
+		socks4 := startSocksServer(t, handleSocks4)
+		defer socks4.Close() //nolint: errcheck
+
+		r, closer, err := NewResolver(proxyURL, "tcp://"+dnsServer.Addr().String())
+		require.NoError(t, err)
+		defer closer.Close() //nolint: errcheck
+
+		d, err := NewDialer("socks4://"+socks4.Addr().String(), WithResolver(r))
+		require.NoError(t, err)
+
+		_, port, _ := net.SplitHostPort(echo.Addr().String())
+		conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("example.test", port))
+		require.NoError(t, err)
+		requireEcho(t, conn)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, _, err := NewResolver(proxyURL, "quic://1.1.1.1")
+		require.ErrorIs(t, err, ErrConfigUnsupported)
+	})
+}
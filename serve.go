@@ -0,0 +1,409 @@
+package proxyclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serveHandshakeTimeout bounds how long a client may take to tell the local
+// server where it wants to go.
+const serveHandshakeTimeout = 30 * time.Second
+
+// Serve exposes proxyURL as a local proxy on listenAddr, so tools that only
+// speak SOCKS5 or HTTP can use any registered scheme. Both protocols share
+// the port: SOCKS5 clients and HTTP clients (CONNECT tunnels as well as
+// plain absolute-URI requests) are told apart by their first byte.
+//
+// Serve blocks until ctx is done, then closes the listener, every open
+// connection and the dialer of proxyURL and returns nil.
+func Serve(ctx context.Context, listenAddr, proxyURL string, options ...Option) error {
+	d, err := NewDialer(proxyURL, options...)
+	if err != nil {
+		return err
+	}
+	defer closeDialer(d) //nolint: errcheck
+
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+
+	return ServeListener(ctx, ln, d)
+}
+
+// ServeListener is like Serve, but accepts connections on ln and dials the
+// targets through d.
+func ServeListener(ctx context.Context, ln net.Listener, d ContextDialer) error {
+	s := &localServer{
+		ctx:    ctx,
+		dialer: d,
+		conns:  make(map[net.Conn]struct{}),
+		transport: &http.Transport{
+			DialContext:     d.DialContext,
+			MaxIdleConns:    100,
+			IdleConnTimeout: 90 * time.Second,
+		},
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		ln.Close() //nolint: errcheck
+		s.closeAll()
+	})
+	defer stop()
+
+	defer s.wg.Wait()
+	defer s.transport.CloseIdleConnections()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+
+			ln.Close() //nolint: errcheck
+			s.closeAll()
+			return err
+		}
+
+		if !s.track(conn) {
+			conn.Close() //nolint: errcheck
+			return nil
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+type localServer struct {
+	ctx       context.Context
+	dialer    ContextDialer
+	transport *http.Transport
+
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func (s *localServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *localServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	conn.Close() //nolint: errcheck
+}
+
+func (s *localServer) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close() //nolint: errcheck
+	}
+}
+
+func (s *localServer) serveConn(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(serveHandshakeTimeout)) //nolint: errcheck
+
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+
+	if first[0] == socks5Version {
+		s.serveSocks5(conn, br)
+		return
+	}
+
+	s.serveHTTP(conn, br)
+}
+
+func (s *localServer) serveSocks5(conn net.Conn, br *bufio.Reader) {
+	// greeting: VER NMETHODS METHODS...
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return
+	}
+
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return
+	}
+
+	method := byte(socks5AuthNotAvailable)
+	for _, m := range methods {
+		if m == socks5AuthNone {
+			method = socks5AuthNone
+			break
+		}
+	}
+
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil || method != socks5AuthNone {
+		return
+	}
+
+	// request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	var req [4]byte
+	if _, err := io.ReadFull(br, req[:]); err != nil || req[0] != socks5Version {
+		return
+	}
+
+	addr, err := readSocks5Addr(br, req[3])
+	if err != nil {
+		writeSocks5Reply(conn, Socks5AddrTypeUnsupported) //nolint: errcheck
+		return
+	}
+
+	if req[1] != socks5CmdConnect {
+		writeSocks5Reply(conn, Socks5CommandUnsupported) //nolint: errcheck
+		return
+	}
+
+	target, err := s.dial(addr)
+	if err != nil {
+		writeSocks5Reply(conn, socks5ReplyCode(err)) //nolint: errcheck
+		return
+	}
+	defer target.Close() //nolint: errcheck
+
+	if err := writeSocks5Reply(conn, Socks5Succeeded); err != nil {
+		return
+	}
+
+	conn.SetReadDeadline(time.Time{}) //nolint: errcheck
+	relay(conn, br, target)
+}
+
+// dial connects to addr through the proxy for a client, giving up after
+// serveHandshakeTimeout. Some dialers keep using the context for the life
+// of the connection, so it is only cancelled when the dial fails or the
+// connection is closed.
+func (s *localServer) dial(addr string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	timer := time.AfterFunc(serveHandshakeTimeout, cancel)
+
+	conn, err := s.dialer.DialContext(ctx, "tcp", addr)
+	if !timer.Stop() {
+		if err == nil {
+			conn.Close() //nolint: errcheck
+		}
+		err = fmt.Errorf("dial %s: %w", addr, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &cancelConn{Conn: conn, cancel: cancel}, nil
+}
+
+// cancelConn cancels the context it was dialed with once it is closed.
+type cancelConn struct {
+	net.Conn
+	cancel context.CancelFunc
+}
+
+func (c *cancelConn) Close() error {
+	err := c.Conn.Close()
+	c.cancel()
+	return err
+}
+
+// writeSocks5Reply writes a reply with an unspecified bound address; the
+// relay does not expose where the proxy connected from.
+func writeSocks5Reply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{socks5Version, code, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func socks5ReplyCode(err error) byte {
+	// when the upstream proxy itself failed, its errors say nothing
+	// about the target
+	kind := Classify(err)
+	if kind != nil && kind != ErrTargetUnreachable {
+		return Socks5GeneralFailure
+	}
+
+	// an upstream SOCKS5 server already said why
+	var socksErr *Socks5Error
+	if errors.As(err, &socksErr) {
+		return socksErr.Code
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return Socks5ConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return Socks5NetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return Socks5HostUnreachable
+	}
+
+	var dnsErr *net.DNSError
+	var ne net.Error
+	if errors.As(err, &dnsErr) || (errors.As(err, &ne) && ne.Timeout()) || kind == ErrTargetUnreachable {
+		return Socks5HostUnreachable
+	}
+
+	return Socks5GeneralFailure
+}
+
+func (s *localServer) serveHTTP(conn net.Conn, br *bufio.Reader) {
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+
+		if req.Method == http.MethodConnect {
+			s.serveConnect(conn, br, req)
+			return
+		}
+
+		conn.SetReadDeadline(time.Time{}) //nolint: errcheck
+		if !s.forwardHTTP(conn, req) {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(serveHandshakeTimeout)) //nolint: errcheck
+	}
+}
+
+func (s *localServer) serveConnect(conn net.Conn, br *bufio.Reader, req *http.Request) {
+	addr := req.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+
+	target, err := s.dial(addr)
+	if err != nil {
+		writeHTTPError(conn, http.StatusBadGateway, err)
+		return
+	}
+	defer target.Close() //nolint: errcheck
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	conn.SetReadDeadline(time.Time{}) //nolint: errcheck
+	relay(conn, br, target)
+}
+
+// hopHeaders are the headers that apply to a single connection and must
+// not be forwarded (RFC 9110, section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// forwardHTTP sends a plain proxy request on and copies the response back.
+// It reports whether the client connection can carry another request.
+func (s *localServer) forwardHTTP(conn net.Conn, req *http.Request) bool {
+	if !req.URL.IsAbs() {
+		writeHTTPError(conn, http.StatusBadRequest, errors.New("request URI must be absolute"))
+		return false
+	}
+
+	keepAlive := !req.Close
+
+	for _, v := range req.Header.Values("Connection") {
+		for _, h := range strings.Split(v, ",") {
+			req.Header.Del(strings.TrimSpace(h))
+		}
+	}
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+
+	req.RequestURI = ""
+	req = req.WithContext(s.ctx)
+
+	resp, err := s.transport.RoundTrip(req)
+	if err != nil {
+		writeHTTPError(conn, http.StatusBadGateway, err)
+		return false
+	}
+	defer resp.Body.Close() //nolint: errcheck
+
+	for _, h := range hopHeaders {
+		if h != "Transfer-Encoding" {
+			resp.Header.Del(h)
+		}
+	}
+	resp.Close = !keepAlive
+
+	return resp.Write(conn) == nil && keepAlive
+}
+
+func writeHTTPError(w io.Writer, code int, err error) {
+	body := err.Error() + "\n"
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", //nolint: errcheck
+		code, http.StatusText(code), len(body), body)
+}
+
+// relay copies data both ways until either side is done, then closes
+// both so the other copy returns too. br holds whatever the client sent
+// after its handshake.
+func relay(client net.Conn, br *bufio.Reader, target net.Conn) {
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			client.Close() //nolint: errcheck
+			target.Close() //nolint: errcheck
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(target, br) //nolint: errcheck
+		closeBoth()
+	}()
+
+	io.Copy(client, target) //nolint: errcheck
+	closeBoth()
+
+	<-done
+}
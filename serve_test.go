@@ -0,0 +1,133 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close() //nolint: errcheck
+
+	upstream := startSocksServer(t, handleSocks5)
+	defer upstream.Close() //nolint: errcheck
+
+	d, err := NewDialer(fmt.Sprintf("socks5://%s", upstream.Addr()))
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeListener(ctx, ln, d)
+	}()
+
+	local := ln.Addr().String()
+
+	t.Run("socks5", func(t *testing.T) {
+		d, err := NewDialer("socks5://" + local)
+		require.NoError(t, err)
+
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn)
+	})
+
+	t.Run("socks5 unreachable", func(t *testing.T) {
+		d, err := NewDialer("socks5://" + local)
+		require.NoError(t, err)
+
+		_, err = d.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+		require.Error(t, err)
+	})
+
+	t.Run("socks5 bad version", func(t *testing.T) {
+		conn, err := net.Dial("tcp", local)
+		require.NoError(t, err)
+		defer conn.Close() //nolint: errcheck
+
+		_, err = conn.Write([]byte{socks5Version, 1, socks5AuthNone})
+		require.NoError(t, err)
+		reply := make([]byte, 2)
+		_, err = io.ReadFull(conn, reply)
+		require.NoError(t, err)
+
+		// a SOCKS4 request behind a SOCKS5 greeting
+		_, err = conn.Write([]byte{4, socks5CmdConnect, 0, socks5AddrIPv4, 127, 0, 0, 1, 0, 80})
+		require.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint: errcheck
+		_, err = conn.Read(reply)
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("http connect", func(t *testing.T) {
+		d, err := NewDialer("http://" + local)
+		require.NoError(t, err)
+
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn)
+	})
+
+	t.Run("http forward", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "Hello from %s", r.URL.Path) //nolint: errcheck
+		}))
+		defer targetServer.Close()
+
+		proxyURL, err := url.Parse("http://" + local)
+		require.NoError(t, err)
+
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		defer client.CloseIdleConnections()
+
+		for _, path := range []string{"/a", "/b"} {
+			resp, err := client.Get(targetServer.URL + path)
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close() //nolint: errcheck
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "Hello from "+path, string(body))
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		conn, err := net.Dial("tcp", local)
+		require.NoError(t, err)
+		defer conn.Close() //nolint: errcheck
+
+		cancel()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("ServeListener did not return after cancel")
+		}
+
+		// open connections are closed with the server
+		conn.SetReadDeadline(time.Now().Add(time.Second)) //nolint: errcheck
+		_, err = conn.Read(make([]byte, 1))
+		var ne net.Error
+		require.Error(t, err)
+		require.False(t, errors.As(err, &ne) && ne.Timeout(), "connection left open: %v", err)
+
+		_, err = net.Dial("tcp", local)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,164 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Reply codes a SOCKS4 server answers a request with.
+const (
+	Socks4Granted           byte = 90
+	Socks4Rejected          byte = 91
+	Socks4IdentdUnreachable byte = 92
+	Socks4IdentdMismatch    byte = 93
+)
+
+// Socks4Error is a request a SOCKS4 server turned down. It wraps
+// ErrTargetUnreachable for Socks4Rejected, ErrProxyAuthFailed for the
+// identd failures and ErrHandshakeFailed for anything else.
+type Socks4Error struct {
+	Code byte
+	Addr string
+}
+
+func (e *Socks4Error) Error() string {
+	var reason string
+	switch e.Code {
+	case Socks4Rejected:
+		reason = "request rejected or failed"
+	case Socks4IdentdUnreachable:
+		reason = "server cannot reach identd on the client"
+	case Socks4IdentdMismatch:
+		reason = "identd reports a different user ID"
+	default:
+		reason = "unknown reply code " + strconv.Itoa(int(e.Code))
+	}
+
+	return fmt.Sprintf("socks4: CONNECT %s: %s", e.Addr, reason)
+}
+
+func (e *Socks4Error) Unwrap() error {
+	switch e.Code {
+	case Socks4Rejected:
+		return ErrTargetUnreachable
+	case Socks4IdentdUnreachable, Socks4IdentdMismatch:
+		return ErrProxyAuthFailed
+	default:
+		return ErrHandshakeFailed
+	}
+}
+
+// socks4Client opens connections through a SOCKS4 or, when remoteDNS is
+// set, SOCKS4a server.
+type socks4Client struct {
+	server    string
+	userID    string
+	remoteDNS bool
+	forward   ContextDialer
+	resolver  *net.Resolver
+}
+
+func (c *socks4Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("socks4: network %s: %w", network, ErrConfigUnsupported)
+	}
+
+	req, err := c.request(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.forward.DialContext(ctx, "tcp", c.server)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProxyUnreachable, err)
+	}
+
+	// Abort the handshake as soon as ctx is done; the connection is
+	// handed back to the caller untouched once the server has replied.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close() //nolint: errcheck
+	})
+
+	err = socks4Handshake(conn, req, addr)
+	if !stop() {
+		conn.Close() //nolint: errcheck
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close() //nolint: errcheck
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// request builds the CONNECT request for addr. socks4 can only carry an
+// IPv4 address, so a host name is resolved here; socks4a hands it to the
+// server instead.
+func (c *socks4Client) request(ctx context.Context, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks4: invalid port %q", portStr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil && !c.remoteDNS {
+		resolved, err := resolveTarget(ctx, c.resolver, addr)
+		if err != nil {
+			return nil, err
+		}
+		host, _, _ = net.SplitHostPort(resolved)
+		ip = net.ParseIP(host)
+	}
+
+	req := []byte{4, 1, byte(port >> 8), byte(port)}
+	switch {
+	case ip != nil:
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("socks4: %s is no IPv4 address: %w", host, ErrTargetUnreachable)
+		}
+		req = append(req, ip4...)
+		req = append(req, c.userID...)
+		req = append(req, 0)
+	default:
+		// 0.0.0.x with a non-zero x tells a SOCKS4a server that the host
+		// name follows the user ID.
+		req = append(req, 0, 0, 0, 1)
+		req = append(req, c.userID...)
+		req = append(req, 0)
+		req = append(req, host...)
+		req = append(req, 0)
+	}
+
+	return req, nil
+}
+
+func socks4Handshake(conn net.Conn, req []byte, addr string) error {
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+
+	var reply [8]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+
+	if reply[0] != 0 {
+		return fmt.Errorf("%w: socks4: unexpected reply version %d", ErrHandshakeFailed, reply[0])
+	}
+	if reply[1] != Socks4Granted {
+		return &Socks4Error{Code: reply[1], Addr: addr}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package proxyclient
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// socks4Request is a CONNECT request as read by readSocks4Request.
+type socks4Request struct {
+	port   int
+	ip     net.IP
+	userID string
+	host   string
+}
+
+func readSocks4Request(conn net.Conn) (*socks4Request, error) {
+	br := bufio.NewReader(conn)
+
+	var head [8]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return nil, err
+	}
+
+	req := &socks4Request{
+		port: int(head[2])<<8 | int(head[3]),
+		ip:   net.IPv4(head[4], head[5], head[6], head[7]),
+	}
+
+	userID, err := br.ReadString(0)
+	if err != nil {
+		return nil, err
+	}
+	req.userID = userID[:len(userID)-1]
+
+	if head[4] == 0 && head[5] == 0 && head[6] == 0 && head[7] != 0 {
+		host, err := br.ReadString(0)
+		if err != nil {
+			return nil, err
+		}
+		req.host = host[:len(host)-1]
+	}
+
+	return req, nil
+}
+
+func TestSocks4Dialer(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close() //nolint: errcheck
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	requests := make(chan *socks4Request, 1)
+	proxy := startSocksServer(t, func(conn net.Conn, t *testing.T) {
+		defer conn.Close() //nolint: errcheck
+
+		req, err := readSocks4Request(conn)
+		if err != nil {
+			return
+		}
+		requests <- req
+
+		code := Socks4Granted
+		switch req.userID {
+		case "reject":
+			code = Socks4Rejected
+		case "identd":
+			code = Socks4IdentdMismatch
+		case "stall":
+			time.Sleep(time.Second)
+			return
+		}
+		conn.Write([]byte{0, code, 0, 0, 0, 0, 0, 0}) //nolint: errcheck
+		if code != Socks4Granted {
+			return
+		}
+
+		target, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(req.port)))
+		if err != nil {
+			return
+		}
+		go transfer(target, conn)
+		transfer(conn, target)
+	})
+	defer proxy.Close() //nolint: errcheck
+
+	dial := func(ctx context.Context, proxyURL, addr string) (net.Conn, error) {
+		d, err := NewDialer(proxyURL)
+		require.NoError(t, err)
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	t.Run("socks4 resolves locally", func(t *testing.T) {
+		conn, err := dial(context.Background(), "socks4://alice@"+proxy.Addr().String(), net.JoinHostPort("localhost", echoPort))
+		require.NoError(t, err)
+		requireEcho(t, conn)
+
+		req := <-requests
+		require.Equal(t, "alice", req.userID)
+		require.Equal(t, "127.0.0.1", req.ip.String())
+		require.Empty(t, req.host)
+	})
+
+	t.Run("socks4a resolves remotely", func(t *testing.T) {
+		conn, err := dial(context.Background(), "socks4a://"+proxy.Addr().String(), net.JoinHostPort("localhost", echoPort))
+		require.NoError(t, err)
+		requireEcho(t, conn)
+
+		req := <-requests
+		require.Equal(t, "localhost", req.host)
+	})
+
+	t.Run("reply codes", func(t *testing.T) {
+		_, err := dial(context.Background(), "socks4://reject@"+proxy.Addr().String(), echo.Addr().String())
+		var socksErr *Socks4Error
+		require.ErrorAs(t, err, &socksErr)
+		require.Equal(t, Socks4Rejected, socksErr.Code)
+		require.ErrorIs(t, err, ErrTargetUnreachable)
+		<-requests
+
+		_, err = dial(context.Background(), "socks4://identd@"+proxy.Addr().String(), echo.Addr().String())
+		require.ErrorAs(t, err, &socksErr)
+		require.Equal(t, Socks4IdentdMismatch, socksErr.Code)
+		require.ErrorIs(t, err, ErrProxyAuthFailed)
+		<-requests
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := dial(ctx, "socks4://stall@"+proxy.Addr().String(), echo.Addr().String())
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+		<-requests
+	})
+
+	t.Run("ipv6 target", func(t *testing.T) {
+		_, err := dial(context.Background(), "socks4://"+proxy.Addr().String(), "[::1]:80")
+		require.ErrorIs(t, err, ErrTargetUnreachable)
+	})
+}
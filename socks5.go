@@ -0,0 +1,426 @@
+package proxyclient
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SOCKS5 protocol values (RFC 1928).
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNotAvailable = 0xff
+
+	socks5CmdConnect      = 0x01
+	socks5CmdBind         = 0x02
+	socks5CmdUDPAssociate = 0x03
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+// Reply codes a SOCKS5 server answers a request with.
+const (
+	Socks5Succeeded           byte = 0x00
+	Socks5GeneralFailure      byte = 0x01
+	Socks5NotAllowed          byte = 0x02
+	Socks5NetworkUnreachable  byte = 0x03
+	Socks5HostUnreachable     byte = 0x04
+	Socks5ConnectionRefused   byte = 0x05
+	Socks5TTLExpired          byte = 0x06
+	Socks5CommandUnsupported  byte = 0x07
+	Socks5AddrTypeUnsupported byte = 0x08
+)
+
+var socks5Commands = map[byte]string{
+	socks5CmdConnect:      "CONNECT",
+	socks5CmdBind:         "BIND",
+	socks5CmdUDPAssociate: "UDP ASSOCIATE",
+}
+
+var socks5Replies = map[byte]string{
+	Socks5GeneralFailure:      "general SOCKS server failure",
+	Socks5NotAllowed:          "connection not allowed by ruleset",
+	Socks5NetworkUnreachable:  "network unreachable",
+	Socks5HostUnreachable:     "host unreachable",
+	Socks5ConnectionRefused:   "connection refused",
+	Socks5TTLExpired:          "TTL expired",
+	Socks5CommandUnsupported:  "command not supported",
+	Socks5AddrTypeUnsupported: "address type not supported",
+}
+
+// Socks5Error is a request a SOCKS5 server turned down, with the reply code
+// it gave. It wraps ErrTargetUnreachable when the server could not reach
+// Addr, ErrUDPUnsupported or ErrConfigUnsupported when it does not support
+// the command or address type, and ErrHandshakeFailed otherwise.
+type Socks5Error struct {
+	Command string
+	Addr    string
+	Code    byte
+}
+
+func (e *Socks5Error) Error() string {
+	reason, ok := socks5Replies[e.Code]
+	if !ok {
+		reason = "unknown reply code " + strconv.Itoa(int(e.Code))
+	}
+
+	return fmt.Sprintf("socks5: %s %s: %s", e.Command, e.Addr, reason)
+}
+
+func (e *Socks5Error) Unwrap() error {
+	switch e.Code {
+	case Socks5NotAllowed, Socks5NetworkUnreachable, Socks5HostUnreachable, Socks5ConnectionRefused, Socks5TTLExpired:
+		return ErrTargetUnreachable
+	case Socks5CommandUnsupported:
+		if e.Command == socks5Commands[socks5CmdUDPAssociate] {
+			return ErrUDPUnsupported
+		}
+		return ErrConfigUnsupported
+	case Socks5AddrTypeUnsupported:
+		return ErrConfigUnsupported
+	default:
+		return ErrHandshakeFailed
+	}
+}
+
+// Socks5Client opens connections through a SOCKS5 server. Besides CONNECT
+// (DialContext) it supports BIND (Bind) and UDP ASSOCIATE (ListenPacket).
+// It offers username/password authentication when the URL carries a user
+// and no authentication otherwise.
+type Socks5Client struct {
+	server   string
+	user     string
+	password string
+	auth     bool
+	forward  ContextDialer
+	// packetForward is the caller's forward dialer, which UDP ASSOCIATE
+	// also sends its datagrams through.
+	packetForward ContextDialer
+}
+
+// DialContext connects to addr through the server with CONNECT.
+func (c *Socks5Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("socks5: network %s: %w", network, ErrConfigUnsupported)
+	}
+
+	conn, err := c.dialServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := c.request(ctx, conn, socks5CmdConnect, addr); err != nil {
+		conn.Close() //nolint: errcheck
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Bind asks the server to accept one connection from addr, the peer that
+// is expected to connect, as FTP-style protocols need. Hand the peer the
+// address of the returned listener; Accept then waits for it to connect.
+// Closing the listener abandons the request.
+func (c *Socks5Client) Bind(ctx context.Context, addr string) (net.Listener, error) {
+	conn, err := c.dialServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bound, err := c.request(ctx, conn, socks5CmdBind, addr)
+	if err != nil {
+		conn.Close() //nolint: errcheck
+		return nil, err
+	}
+
+	return &socks5Listener{
+		conn: conn,
+		addr: streamAddr(c.serverAddr(bound)),
+		peer: addr,
+	}, nil
+}
+
+func (c *Socks5Client) dialServer(ctx context.Context) (net.Conn, error) {
+	conn, err := c.forward.DialContext(ctx, "tcp", c.server)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProxyUnreachable, err)
+	}
+	return conn, nil
+}
+
+// serverAddr replaces an unspecified host in addr, which a server replies
+// with to mean itself, by the server's host.
+func (c *Socks5Client) serverAddr(addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		serverHost, _, _ := net.SplitHostPort(c.server)
+		return net.JoinHostPort(serverHost, port)
+	}
+	return addr
+}
+
+// request negotiates authentication on conn and sends cmd for addr,
+// giving up when ctx is done. It returns the address the server replied
+// with.
+func (c *Socks5Client) request(ctx context.Context, conn net.Conn, cmd byte, addr string) (string, error) {
+	// The deadline of ctx is left to the AfterFunc: copied onto conn it
+	// could fire first and pass the caller's timeout off as the proxy's.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0)) //nolint: errcheck
+	})
+
+	bound, err := c.handshake(conn, cmd, addr)
+	if !stop() || ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", classifyAs(ErrHandshakeFailed, err)
+	}
+
+	conn.SetDeadline(time.Time{}) //nolint: errcheck
+	return bound, nil
+}
+
+func (c *Socks5Client) handshake(conn net.Conn, cmd byte, addr string) (string, error) {
+	methods := []byte{socks5AuthNone}
+	if c.auth {
+		methods = append(methods, socks5AuthPassword)
+	}
+
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return "", err
+	}
+
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return "", err
+	}
+	if choice[0] != socks5Version {
+		return "", fmt.Errorf("socks5: unexpected protocol version %d", choice[0])
+	}
+
+	switch choice[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if !c.auth || len(c.user) > 255 || len(c.password) > 255 {
+			return "", fmt.Errorf("socks5: invalid username/password: %w", ErrProxyAuthFailed)
+		}
+
+		// RFC 1929: VER ULEN UNAME PLEN PASSWD
+		b := []byte{0x01, byte(len(c.user))}
+		b = append(b, c.user...)
+		b = append(b, byte(len(c.password)))
+		b = append(b, c.password...)
+		if _, err := conn.Write(b); err != nil {
+			return "", err
+		}
+
+		var status [2]byte
+		if _, err := io.ReadFull(conn, status[:]); err != nil {
+			return "", err
+		}
+		if status[1] != 0x00 {
+			return "", fmt.Errorf("socks5: %w", ErrProxyAuthFailed)
+		}
+	default:
+		return "", fmt.Errorf("socks5: no acceptable authentication methods: %w", ErrProxyAuthFailed)
+	}
+
+	req, err := appendSocks5Addr([]byte{socks5Version, cmd, 0}, addr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrConfigUnsupported, err)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+
+	return readSocks5Reply(conn, cmd, addr)
+}
+
+// readSocks5Reply reads a reply to cmd and returns its BND.ADDR.
+func readSocks5Reply(r io.Reader, cmd byte, addr string) (string, error) {
+	var reply [4]byte
+	if _, err := io.ReadFull(r, reply[:]); err != nil {
+		return "", err
+	}
+	if reply[0] != socks5Version {
+		return "", fmt.Errorf("socks5: unexpected protocol version %d", reply[0])
+	}
+	if reply[1] != Socks5Succeeded {
+		return "", &Socks5Error{Command: socks5Commands[cmd], Addr: addr, Code: reply[1]}
+	}
+
+	return readSocks5Addr(r, reply[3])
+}
+
+// socks5Listener is a BIND request waiting for its one connection.
+type socks5Listener struct {
+	conn net.Conn
+	addr net.Addr
+	peer string
+
+	mu        sync.Mutex
+	accepting bool
+	accepted  bool
+	closed    bool
+}
+
+// Accept waits for the server's second reply, which tells that the peer
+// has connected, and returns the connection to it. Later calls, and calls
+// after Close, return net.ErrClosed.
+func (l *socks5Listener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.accepting || l.accepted || l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	l.accepting = true
+	l.mu.Unlock()
+
+	remote, err := readSocks5Reply(l.conn, socks5CmdBind, l.peer)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.accepting = false
+
+	if l.closed {
+		return nil, net.ErrClosed
+	}
+	if err != nil {
+		l.closed = true
+		l.conn.Close() //nolint: errcheck
+		return nil, classifyAs(ErrHandshakeFailed, err)
+	}
+
+	l.accepted = true
+	return &socks5BindConn{Conn: l.conn, remote: streamAddr(remote)}, nil
+}
+
+// Close abandons the request, unblocking a pending Accept, unless its
+// connection has been accepted; the accepted connection is closed on its
+// own.
+func (l *socks5Listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.accepted || l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.conn.Close()
+}
+
+// Addr returns the address the server listens on for the peer.
+func (l *socks5Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// streamAddr turns a "host:port" reported by a proxy for a TCP connection
+// into a net.Addr: a *net.TCPAddr, or a HostAddr for a domain name.
+func streamAddr(addr string) net.Addr {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return net.TCPAddrFromAddrPort(ap)
+	}
+
+	return tcpHostAddr(addr)
+}
+
+// tcpHostAddr is an unresolved "host:port" TCP address.
+type tcpHostAddr string
+
+func (a tcpHostAddr) Network() string { return "tcp" }
+func (a tcpHostAddr) String() string  { return string(a) }
+
+// socks5BindConn reports the peer of a BIND as its remote address.
+type socks5BindConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *socks5BindConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// readSocks5Addr reads DST.ADDR and DST.PORT of the given address type.
+func readSocks5Addr(r io.Reader, atyp byte) (string, error) {
+	var host string
+
+	switch atyp {
+	case socks5AddrIPv4:
+		ip := make(net.IP, net.IPv4len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddrIPv6:
+		ip := make(net.IP, net.IPv6len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("socks5: unknown address type %d", atyp)
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// appendSocks5Addr appends addr as ATYP, DST.ADDR and DST.PORT.
+func appendSocks5Addr(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: invalid port %q", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socks5AddrIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socks5AddrIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("socks5: host name too long: %s", host)
+		}
+		b = append(b, socks5AddrDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
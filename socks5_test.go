@@ -0,0 +1,188 @@
+package proxyclient
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// handleSocks5Auth is a SOCKS5 server that requires username/password
+// authentication and serves CONNECT and BIND. The password picks the
+// reply: "refuse" fails the request with Socks5ConnectionRefused and
+// "stall" never answers it.
+func handleSocks5Auth(conn net.Conn, t *testing.T) {
+	defer conn.Close() //nolint: errcheck
+
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{socks5Version, socks5AuthPassword}) //nolint: errcheck
+
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[1:1+int(buf[0])]); err != nil {
+		return
+	}
+	password := string(buf[1 : 1+int(buf[0])])
+	if string(user) != "alice" {
+		conn.Write([]byte{0x01, 0x01}) //nolint: errcheck
+		return
+	}
+	conn.Write([]byte{0x01, 0x00}) //nolint: errcheck
+
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return
+	}
+	addr, err := readSocks5Addr(conn, req[3])
+	if err != nil {
+		return
+	}
+
+	switch password {
+	case "refuse":
+		writeSocks5Reply(conn, Socks5ConnectionRefused) //nolint: errcheck
+		return
+	case "stall":
+		time.Sleep(time.Second)
+		return
+	}
+
+	var target net.Conn
+	switch req[1] {
+	case socks5CmdConnect:
+		if target, err = net.Dial("tcp", addr); err != nil {
+			writeSocks5Reply(conn, Socks5HostUnreachable) //nolint: errcheck
+			return
+		}
+		writeSocks5Reply(conn, Socks5Succeeded) //nolint: errcheck
+	case socks5CmdBind:
+		ln, err := net.Listen("tcp", "0.0.0.0:0")
+		if err != nil {
+			return
+		}
+		defer ln.Close() //nolint: errcheck
+
+		reply, _ := appendSocks5Addr([]byte{socks5Version, Socks5Succeeded, 0}, ln.Addr().String())
+		conn.Write(reply) //nolint: errcheck
+
+		if target, err = ln.Accept(); err != nil {
+			return
+		}
+		reply, _ = appendSocks5Addr([]byte{socks5Version, Socks5Succeeded, 0}, target.RemoteAddr().String())
+		conn.Write(reply) //nolint: errcheck
+	default:
+		writeSocks5Reply(conn, Socks5CommandUnsupported) //nolint: errcheck
+		return
+	}
+
+	go transfer(target, conn)
+	transfer(conn, target)
+}
+
+func TestSocks5Client(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close() //nolint: errcheck
+
+	proxy := startSocksServer(t, handleSocks5Auth)
+	defer proxy.Close() //nolint: errcheck
+
+	client := func(userinfo string) *Socks5Client {
+		d, err := NewDialer("socks5://" + userinfo + "@" + proxy.Addr().String())
+		require.NoError(t, err)
+		return d.(*Socks5Client)
+	}
+
+	t.Run("connect", func(t *testing.T) {
+		conn, err := client("alice:secret").DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn)
+	})
+
+	t.Run("auth failed", func(t *testing.T) {
+		_, err := client("bob:secret").DialContext(context.Background(), "tcp", echo.Addr().String())
+		require.ErrorIs(t, err, ErrProxyAuthFailed)
+	})
+
+	t.Run("reply code", func(t *testing.T) {
+		_, err := client("alice:refuse").DialContext(context.Background(), "tcp", echo.Addr().String())
+		var socksErr *Socks5Error
+		require.ErrorAs(t, err, &socksErr)
+		require.Equal(t, Socks5ConnectionRefused, socksErr.Code)
+		require.Equal(t, "CONNECT", socksErr.Command)
+		require.Equal(t, echo.Addr().String(), socksErr.Addr)
+		require.ErrorIs(t, err, ErrTargetUnreachable)
+	})
+
+	t.Run("bind", func(t *testing.T) {
+		var bd BindDialer = client("alice:secret")
+
+		ln, err := bd.Bind(context.Background(), "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close() //nolint: errcheck
+
+		// the server replied with 0.0.0.0, which means its own host
+		host, port, err := net.SplitHostPort(ln.Addr().String())
+		require.NoError(t, err)
+		require.Equal(t, "127.0.0.1", host)
+		require.IsType(t, &net.TCPAddr{}, ln.Addr())
+
+		peer, err := net.Dial("tcp", net.JoinHostPort(host, port))
+		require.NoError(t, err)
+		defer peer.Close() //nolint: errcheck
+
+		conn, err := ln.Accept()
+		require.NoError(t, err)
+		require.Equal(t, peer.LocalAddr().String(), conn.RemoteAddr().String())
+		require.Equal(t, "tcp", conn.RemoteAddr().Network())
+
+		_, err = peer.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+		conn.Close() //nolint: errcheck
+
+		_, err = ln.Accept()
+		require.ErrorIs(t, err, net.ErrClosed)
+	})
+
+	t.Run("bind closed while accepting", func(t *testing.T) {
+		ln, err := client("alice:secret").Bind(context.Background(), "127.0.0.1:0")
+		require.NoError(t, err)
+
+		time.AfterFunc(50*time.Millisecond, func() {
+			ln.Close() //nolint: errcheck
+		})
+
+		_, err = ln.Accept()
+		require.ErrorIs(t, err, net.ErrClosed)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := client("alice:stall").DialContext(ctx, "tcp", echo.Addr().String())
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
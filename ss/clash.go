@@ -0,0 +1,136 @@
+package ss
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/cnlangzi/proxyclient"
+)
+
+func init() {
+	proxyclient.RegisterClashParser("ss", func(e *proxyclient.ClashEntry) (proxyclient.URL, error) {
+		return FromClash(e)
+	})
+	proxyclient.RegisterClashMarshaler("ss", func(u proxyclient.URL) (map[string]any, error) {
+		v, ok := u.(*URL)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", proxyclient.ErrUnsupportedClashType, u.Protocol())
+		}
+		return ToClash(v.Config)
+	})
+}
+
+// v2rayPluginOpts are the v2ray-plugin options both Clash and SIP003 know.
+var v2rayPluginOpts = []string{"mode", "tls", "host", "path", "mux"}
+
+// FromClash converts a Clash ss entry into a URL. Clash describes plugin
+// options as a mapping; they are turned into the SIP003 "k=v;k" form.
+func FromClash(e *proxyclient.ClashEntry) (*URL, error) {
+	cfg := &Config{
+		Server:   e.String("server"),
+		Port:     e.Int("port"),
+		Method:   e.String("cipher"),
+		Password: e.String("password"),
+		Name:     e.String("name"),
+	}
+
+	opts := e.Entry("plugin-opts")
+	var items []string
+
+	switch plugin := e.String("plugin"); plugin {
+	case "":
+	case "obfs":
+		cfg.Plugin = "obfs-local"
+		if v := opts.String("mode"); v != "" {
+			items = append(items, "obfs="+v)
+		}
+		if v := opts.String("host"); v != "" {
+			items = append(items, "obfs-host="+v)
+		}
+	case "v2ray-plugin":
+		cfg.Plugin = plugin
+		for _, k := range v2rayPluginOpts {
+			if !opts.Has(k) {
+				continue
+			}
+			switch v := opts.String(k); v {
+			case "true":
+				items = append(items, k)
+			case "false":
+			default:
+				items = append(items, k+"="+v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("plugin %q is not supported", plugin)
+	}
+	cfg.PluginOpts = strings.Join(items, ";")
+
+	u := &URL{Config: cfg}
+	raw, err := url.Parse(u.Encode())
+	if err != nil {
+		return nil, err
+	}
+	cfg.raw = raw
+
+	return u, nil
+}
+
+// ToClash converts a Config into a Clash ss entry.
+func ToClash(cfg *Config) (map[string]any, error) {
+	p := map[string]any{
+		"name":     cfg.Name,
+		"type":     "ss",
+		"server":   cfg.Server,
+		"port":     cfg.Port,
+		"cipher":   cfg.Method,
+		"password": cfg.Password,
+	}
+
+	if cfg.Plugin == "" {
+		return p, nil
+	}
+
+	opts := map[string]any{}
+	for _, item := range strings.Split(cfg.PluginOpts, ";") {
+		if item == "" {
+			continue
+		}
+		if k, v, ok := strings.Cut(item, "="); ok {
+			opts[k] = v
+		} else {
+			opts[k] = true
+		}
+	}
+
+	switch cfg.Plugin {
+	case "obfs-local", "simple-obfs":
+		obfs := map[string]any{}
+		for k, v := range opts {
+			switch k {
+			case "obfs":
+				obfs["mode"] = v
+			case "obfs-host":
+				obfs["host"] = v
+			default:
+				return nil, fmt.Errorf("obfs option %q has no clash equivalent", k)
+			}
+		}
+		p["plugin"] = "obfs"
+		p["plugin-opts"] = obfs
+	case "v2ray-plugin":
+		for k := range opts {
+			if !slices.Contains(v2rayPluginOpts, k) {
+				return nil, fmt.Errorf("v2ray-plugin option %q has no clash equivalent", k)
+			}
+		}
+		p["plugin"] = cfg.Plugin
+		p["plugin-opts"] = opts
+	default:
+		return nil, fmt.Errorf("plugin %q has no clash equivalent", cfg.Plugin)
+	}
+
+	return p, nil
+}
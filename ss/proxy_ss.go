@@ -3,6 +3,7 @@ package ss
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -15,6 +16,8 @@ import (
 
 func init() {
 	proxyclient.RegisterProxy("ss", DialSS)
+	proxyclient.RegisterDialer("ss", SSDialer)
+	proxyclient.RegisterCapabilities("ss", proxyclient.SchemeCapabilities{Package: "ss", Network: "tcp", TCP: true, UDP: true, RemoteDNS: true, Auth: true})
 }
 
 // // ProxySS creates a RoundTripper for Shadowsocks proxy
@@ -31,26 +34,28 @@ func init() {
 // 	return proxyclient.ProxySocks5(proxyURL, o)
 // }
 
-func DialSS(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
+// SSDialer creates a dialer that opens Shadowsocks connections to the server
+// in u.
+func SSDialer(u *url.URL, o *proxyclient.Options) (proxyclient.ContextDialer, error) {
 
 	su, err := ParseSSURL(u)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Shadowsocks URL: %w", err)
+		return nil, fmt.Errorf("%w: failed to parse Shadowsocks URL: %w", proxyclient.ErrConfigUnsupported, err)
 	}
 	cfg := su.Config
 
 	m, err := createMethod(cfg.Method, cfg.Password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Shadowsocks method: %w", err)
+		return nil, fmt.Errorf("%w: failed to create Shadowsocks method: %w", proxyclient.ErrConfigUnsupported, err)
 	}
 
-	tr := proxyclient.CreateTransport(o)
+	serverAddr := net.JoinHostPort(cfg.Server, strconv.Itoa(cfg.Port))
+	forward := o.ForwardDialer()
 
-	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		serverAddr := net.JoinHostPort(cfg.Server, strconv.Itoa(cfg.Port))
-		conn, err := net.Dial("tcp", serverAddr)
+	dial := proxyclient.Dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := forward.DialContext(ctx, "tcp", serverAddr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to connect to Shadowsocks server: %w", err)
+			return nil, fmt.Errorf("%w: failed to connect to Shadowsocks server: %w", proxyclient.ErrProxyUnreachable, err)
 		}
 
 		destination := metadata.ParseSocksaddr(addr)
@@ -71,33 +76,92 @@ func DialSS(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
 		}()
 
 		ssConn, err := proxyclient.WithRecover(func() (net.Conn, error) {
-			c, err := m.DialConn(conn, destination)
-			if err != nil {
-				return nil, err
-			}
-
-			return proxyclient.SetDeadline(c, o.Timeout, tr.DisableKeepAlives)
+			return m.DialConn(conn, destination)
 		})
 
-		if ssConn == nil {
-			// panic recovered by WithRecover
-			return nil, fmt.Errorf("ss: dial panic on %s", su.Raw().String())
+		if errors.Is(err, proxyclient.ErrDialPanic) {
+			return nil, fmt.Errorf("ss: %s: %w", su.Raw().String(), err)
 		}
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to create Shadowsocks connection: %w", err)
+			return nil, fmt.Errorf("%w: failed to create Shadowsocks connection: %w", proxyclient.ErrHandshakeFailed, err)
 		}
 
 		// Hand ownership of conn over to ssConn; the deferred close
 		// becomes a no-op because conn is now nil.
 		conn = nil
 		return ssConn, nil
+	})
+
+	listenPacket := func(ctx context.Context, network string) (net.PacketConn, error) {
+		conn, err := dialUDP(ctx, o.Forward, serverAddr)
+		if err != nil {
+			if errors.Is(err, proxyclient.ErrChainUnsupported) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: failed to connect to Shadowsocks server: %w", proxyclient.ErrProxyUnreachable, err)
+		}
+
+		return m.DialPacketConn(conn), nil
+	}
+
+	return &proxyclient.UDPDialer{
+		Dialer:           dial,
+		ListenPacketFunc: listenPacket,
+	}, nil
+}
+
+// dialUDP opens a UDP socket that sends to and receives from serverAddr
+// only, going through forward if it is set.
+func dialUDP(ctx context.Context, forward proxyclient.ContextDialer, serverAddr string) (net.Conn, error) {
+	if forward == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, "udp", serverAddr)
 	}
 
+	pd, ok := forward.(proxyclient.PacketDialer)
+	if !ok {
+		return nil, fmt.Errorf("ss UDP needs a UDP capable forward dialer: %w", proxyclient.ErrChainUnsupported)
+	}
+
+	pc, err := pd.ListenPacket(ctx, "udp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &packetConn{PacketConn: pc, remote: proxyclient.HostAddr(serverAddr)}, nil
+}
+
+// packetConn turns a PacketConn into a net.Conn bound to a single peer,
+// which is what the Shadowsocks packet ciphers expect.
+type packetConn struct {
+	net.PacketConn
+	remote net.Addr
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.remote)
+}
+
+func (c *packetConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func DialSS(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
+	d, err := SSDialer(u, o)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := proxyclient.CreateDialerTransport(d, o)
+
 	tr.DisableCompression = true
 	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 
-	tr.Proxy = nil
-
 	return tr, nil
 }
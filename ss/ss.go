@@ -3,7 +3,6 @@ package ss
 import (
 	"context"
 	"fmt"
-	"io"
 	"net"
 	"net/url"
 	"strings"
@@ -14,7 +13,6 @@ import (
 	shadowsocks "github.com/sagernet/sing-shadowsocks"
 	"github.com/sagernet/sing-shadowsocks/shadowaead"
 	"github.com/sagernet/sing-shadowsocks/shadowaead_2022"
-	md "github.com/sagernet/sing/common/metadata"
 )
 
 var (
@@ -66,173 +64,14 @@ func createMethod(method, password string) (shadowsocks.Method, error) {
 	}
 }
 
-// handleConn handles a single client connection to the SOCKS server
-func handleConn(conn net.Conn, method, password, serverAddr string) {
-	defer conn.Close() //nolint: errcheck
-
-	// Set a read deadline to prevent hanging
-	conn.SetReadDeadline(time.Now().Add(30 * time.Second)) // nolint:errcheck
-
-	// Custom SOCKS5 handshake implementation with more error details
-	// 1. Read the SOCKS version and number of methods
-	buf := make([]byte, 257)
-	n, err := conn.Read(buf)
-	if err != nil {
-		// Only log EOF errors for non-verification connections
-		if err != io.EOF {
-			fmt.Printf("Failed to read SOCKS initial handshake: %v\n", err)
-		}
-		return
-	}
-
-	if n < 2 {
-		fmt.Printf("SOCKS handshake too short: %d bytes\n", n)
-		return
-	}
-
-	if buf[0] != 5 { // SOCKS5
-		fmt.Printf("Unsupported SOCKS version: %d\n", buf[0])
-		return
-	}
-
-	// 2. Send method selection message
-	_, err = conn.Write([]byte{5, 0}) // SOCKS5, no authentication
-	if err != nil {
-		fmt.Printf("Failed to send SOCKS method selection: %v\n", err)
-		return
-	}
-
-	// 3. Read the SOCKS request
-	conn.SetReadDeadline(time.Now().Add(30 * time.Second)) // nolint:errcheck
-	n, err = conn.Read(buf)
-	if err != nil {
-		fmt.Printf("Failed to read SOCKS request: %v\n", err)
-		return
-	}
-
-	if n < 7 {
-		fmt.Printf("SOCKS request too short: %d bytes\n", n)
-		return
-	}
-
-	if buf[0] != 5 { // SOCKS5
-		fmt.Printf("Unsupported SOCKS version in request: %d\n", buf[0])
-		return
-	}
-
-	if buf[1] != 1 { // CONNECT command
-		fmt.Printf("Unsupported SOCKS command: %d\n", buf[1])
-		return
-	}
-
-	var tgt []byte
-	switch buf[3] { // ATYP
-	case 1: // IPv4
-		if n < 10 {
-			fmt.Printf("SOCKS IPv4 request too short: %d bytes\n", n)
-			return
-		}
-		tgt = buf[3:10]
-	case 3: // Domain name
-		addrLen := int(buf[4])
-		if n < 5+addrLen+2 {
-			fmt.Printf("SOCKS domain request too short: %d bytes\n", n)
-			return
-		}
-		tgt = buf[3 : 5+addrLen+2]
-	case 4: // IPv6
-		if n < 22 {
-			fmt.Printf("SOCKS IPv6 request too short: %d bytes\n", n)
-			return
-		}
-		tgt = buf[3:22]
-	default:
-		fmt.Printf("Unsupported SOCKS address type: %d\n", buf[3])
-		return
-	}
-
-	// 4. Send reply - success
-	_, err = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) // SOCKS5, succeeded, IPv4, 0.0.0.0:0
-	if err != nil {
-		fmt.Printf("Failed to send SOCKS reply: %v\n", err)
-		return
-	}
-
-	// Reset read deadline
-	conn.SetReadDeadline(time.Time{}) // nolint:errcheck
-
-	// Parse destination address from tgt
-	var destHost string
-	var destPort int
-
-	switch tgt[0] {
-	case 1: // IPv4
-		destHost = net.IPv4(tgt[1], tgt[2], tgt[3], tgt[4]).String()
-		destPort = int(tgt[5])<<8 | int(tgt[6])
-	case 3: // Domain name
-		addrLen := int(tgt[1])
-		destHost = string(tgt[2 : 2+addrLen])
-		destPort = int(tgt[2+addrLen])<<8 | int(tgt[3+addrLen])
-	case 4: // IPv6
-		destHost = net.IP(tgt[1:17]).String()
-		destPort = int(tgt[17])<<8 | int(tgt[18])
-	}
-
-	fmt.Printf("SOCKS handshake successful, target: %s:%d\n", destHost, destPort)
-
-	// Connect to the Shadowsocks server
-	rc, err := net.Dial("tcp", serverAddr)
-	if err != nil {
-		fmt.Printf("Failed to connect to server %s: %v\n", serverAddr, err)
-		return
-	}
-	defer rc.Close() //nolint: errcheck
-
-	// Create the Shadowsocks method
-	ssMethod, err := createMethod(method, password)
-	if err != nil {
-		fmt.Printf("Failed to create cipher: %v\n", err)
-		return
-	}
-
-	// Create destination address
-	destination := md.ParseSocksaddr(fmt.Sprintf("%s:%d", destHost, destPort))
-
-	// Create a connection to the server
-	ssConn, err := ssMethod.DialConn(rc, destination)
+// startServer starts a local SOCKS5/HTTP server that forwards to the
+// Shadowsocks server in u
+func startServer(u *url.URL, port int) (net.Listener, context.CancelFunc, error) {
+	d, err := SSDialer(u, &proxyclient.Options{})
 	if err != nil {
-		fmt.Printf("Failed to create SS connection: %v\n", err)
-		return
+		return nil, nil, err
 	}
 
-	fmt.Printf("Starting data transfer for %s:%d\n", destHost, destPort)
-
-	// Handle bidirectional copy with better error reporting
-	done := make(chan error, 2)
-
-	// Client to server
-	go func() {
-		_, err := io.Copy(ssConn, conn)
-		done <- err
-		ssConn.Close() //nolint: errcheck
-		fmt.Printf("Client to server copy finished for %s:%d, err: %v\n", destHost, destPort, err)
-	}()
-
-	// Server to client
-	_, err = io.Copy(conn, ssConn)
-	fmt.Printf("Server to client copy finished for %s:%d, err: %v\n", destHost, destPort, err)
-
-	// Wait for the other goroutine to finish
-	clientErr := <-done
-	if clientErr != nil && clientErr != io.EOF {
-		fmt.Printf("Client to server error: %v\n", clientErr)
-	}
-
-	fmt.Printf("Connection to %s:%d closed\n", destHost, destPort)
-}
-
-// startServer starts a SOCKS server that forwards to a Shadowsocks server
-func startServer(port int, method, password, serverAddr string) (net.Listener, context.CancelFunc, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to listen on %d: %w", port, err)
@@ -240,26 +79,7 @@ func startServer(port int, method, password, serverAddr string) (net.Listener, c
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				conn, err := listener.Accept()
-				if err != nil {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						fmt.Printf("Failed to accept connection: %v\n", err)
-						continue
-					}
-				}
-				go handleConn(conn, method, password, serverAddr)
-			}
-		}
-	}()
+	go proxyclient.ServeListener(ctx, listener, d) //nolint: errcheck
 
 	return listener, cancel, nil
 }
@@ -298,14 +118,11 @@ func StartSS(u *url.URL, port int) (int, error) {
 	serverAddr := fmt.Sprintf("%s:%d", cfg.Server, cfg.Port)
 
 	// Start a SOCKS server that forwards to the Shadowsocks server
-	listener, cancel, err := startServer(port, cfg.Method, cfg.Password, serverAddr)
+	listener, cancel, err := startServer(u, port)
 	if err != nil {
 		return 0, err
 	}
 
-	// Add a small delay to ensure the server is ready
-	time.Sleep(100 * time.Millisecond)
-
 	// Store the running server
 	setServer(ssURL, &Server{
 		Method:     cfg.Method,
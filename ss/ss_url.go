@@ -3,6 +3,7 @@ package ss
 import (
 	"encoding/base64"
 	"fmt"
+	"net"
 	"net/url"
 	"strconv"
 	"strings"
@@ -193,3 +194,36 @@ func ParseSSURL(u *url.URL) (*URL, error) {
 
 	return &URL{Config: cfg}, nil
 }
+
+// Encode returns the canonical SIP002 ss:// link of Config. ParseSSURL reads
+// it back into an identical Config.
+func (v *URL) Encode() string {
+	if v.Config == nil {
+		return ""
+	}
+	c := v.Config
+
+	userInfo := base64.RawURLEncoding.EncodeToString([]byte(c.Method + ":" + c.Password))
+
+	u := &url.URL{
+		Scheme:   "ss",
+		User:     url.User(userInfo),
+		Host:     net.JoinHostPort(c.Server, strconv.Itoa(c.Port)),
+		Fragment: c.Name,
+	}
+
+	if c.Plugin != "" {
+		plugin := c.Plugin
+		if c.PluginOpts != "" {
+			plugin += ";" + c.PluginOpts
+		}
+		u.Path = "/"
+		u.RawQuery = url.Values{"plugin": {plugin}}.Encode()
+	}
+
+	return u.String()
+}
+
+func (v *URL) String() string {
+	return v.Encode()
+}
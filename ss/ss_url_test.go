@@ -0,0 +1,150 @@
+package ss
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubscriptionSIP008(t *testing.T) {
+	doc := `{
+		"version": 1,
+		"servers": [
+			{
+				"remarks": "Server 1",
+				"server": "ss.example.com",
+				"server_port": 8388,
+				"password": "p@ss:word",
+				"method": "aes-256-gcm",
+				"plugin": "v2ray-plugin",
+				"plugin_opts": "server;tls"
+			}
+		]
+	}`
+
+	items, errs := proxyclient.ParseSubscription(strings.NewReader(doc))
+	require.Empty(t, errs)
+	require.Len(t, items, 1)
+
+	u, ok := items[0].(*URL)
+	require.True(t, ok)
+	require.Equal(t, &Config{
+		Server:     "ss.example.com",
+		Port:       8388,
+		Method:     "aes-256-gcm",
+		Password:   "p@ss:word",
+		Plugin:     "v2ray-plugin",
+		PluginOpts: "server;tls",
+		Name:       "Server 1",
+		raw:        u.Raw(),
+	}, u.Config)
+}
+
+func TestClash(t *testing.T) {
+	config := `
+proxies:
+  - name: plain
+    type: ss
+    server: 1.2.3.4
+    port: 8388
+    cipher: chacha20-ietf-poly1305
+    password: secret
+  - name: obfs
+    type: ss
+    server: ss.example.com
+    port: 443
+    cipher: aes-256-gcm
+    password: secret
+    plugin: obfs
+    plugin-opts:
+      mode: tls
+      host: bing.com
+  - name: v2ray
+    type: ss
+    server: ss.example.com
+    port: 443
+    cipher: aes-256-gcm
+    password: secret
+    plugin: v2ray-plugin
+    plugin-opts:
+      mode: websocket
+      tls: true
+      host: ss.example.com
+      path: /ws
+      skip-cert-verify: true
+  - name: shadow-tls
+    type: ss
+    server: ss.example.com
+    port: 443
+    cipher: aes-256-gcm
+    password: secret
+    plugin: shadow-tls
+`
+
+	items, errs := proxyclient.ParseClash(strings.NewReader(config))
+	require.Len(t, items, 3)
+	require.Len(t, errs, 2)
+	require.ErrorIs(t, errs[0], proxyclient.ErrUnsupportedClashField)
+	require.Contains(t, errs[0].Error(), "plugin-opts.skip-cert-verify")
+	require.NotErrorIs(t, errs[1], proxyclient.ErrUnsupportedClashField)
+
+	require.Equal(t, "chacha20-ietf-poly1305", items[0].(*URL).Config.Method)
+
+	obfs := items[1].(*URL).Config
+	require.Equal(t, "obfs-local", obfs.Plugin)
+	require.Equal(t, "obfs=tls;obfs-host=bing.com", obfs.PluginOpts)
+
+	v2ray := items[2].(*URL).Config
+	require.Equal(t, "v2ray-plugin", v2ray.Plugin)
+	require.Equal(t, "mode=websocket;tls;host=ss.example.com;path=/ws", v2ray.PluginOpts)
+
+	for _, it := range items {
+		parsed, err := proxyclient.ParseURL(it.Raw().String())
+		require.NoError(t, err)
+
+		want := *it.(*URL).Config
+		got := *parsed.(*URL).Config
+		want.raw, got.raw = nil, nil
+		require.Equal(t, want, got)
+	}
+
+	buf, errs := proxyclient.MarshalClash(items)
+	require.Empty(t, errs)
+
+	again, errs := proxyclient.ParseClash(strings.NewReader(string(buf)))
+	require.Empty(t, errs)
+	require.Len(t, again, 3)
+	for i := range items {
+		require.Equal(t, items[i].Raw().String(), again[i].Raw().String())
+	}
+}
+
+func TestURLEncode(t *testing.T) {
+	links := []string{
+		"ss://YWVzLTI1Ni1nY206c2VjcmV0@1.2.3.4:8388#plain",
+		"ss://MjAyMi1ibGFrZTMtYWVzLTEyOC1nY206TURoaE1UZGpaREkwTWpJMlpXUmxOZz09OlpEUmxaV0ZoTjJJdE5ETmpOaTAwT1E9PQ==@hzhz1.sssyun.xyz:29527#1%7C%F0%9F%87%AD%F0%9F%87%B05%20%7C%20%201.7MB/s",
+		"ss://YWVzLTI1Ni1nY206c2VjcmV0QDEuMi4zLjQ6ODM4OA==#legacy",
+		"ss://YWVzLTI1Ni1nY206c2VjcmV0@[2001:db8::1]:8388/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dbing.com#plugin",
+	}
+
+	for _, link := range links {
+		u, err := proxyclient.ParseURL(link)
+		require.NoError(t, err)
+
+		ssURL := u.(*URL)
+		ssURL.Config.Name = "renamed #1"
+
+		encoded := ssURL.Encode()
+		require.Equal(t, encoded, ssURL.String())
+
+		again, err := proxyclient.ParseURL(encoded)
+		require.NoError(t, err)
+		require.Equal(t, encoded, again.Encode())
+
+		want, got := *ssURL.Config, *again.(*URL).Config
+		want.raw, got.raw = nil, nil
+		require.Equal(t, want, got)
+	}
+}
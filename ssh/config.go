@@ -0,0 +1,195 @@
+package ssh
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultKeepAlive is how often an idle connection is probed when the URL
+// does not set keepalive.
+const DefaultKeepAlive = 30 * time.Second
+
+// Config is an ssh:// proxy URL taken apart. The query may carry:
+//
+//   - key: path of a private key file
+//   - key_data: a private key in PEM form, or base64 of it
+//   - passphrase: the passphrase of an encrypted key
+//   - known_hosts: known_hosts file to check the server's key against
+//     (default ~/.ssh/known_hosts)
+//   - fingerprint: SHA256 fingerprint the server's key must have, as
+//     printed by ssh-keygen -l; it replaces the known_hosts check
+//   - insecure: skip host key verification altogether
+//   - keepalive: interval of keepalive probes, 0 to turn them off
+type Config struct {
+	Server   string
+	User     string
+	Password string
+
+	KeyFile    string
+	KeyData    string
+	Passphrase string
+
+	KnownHosts  string
+	Fingerprint string
+	Insecure    bool
+
+	KeepAlive time.Duration
+}
+
+// ParseConfig reads the SSH proxy settings from u.
+func ParseConfig(u *url.URL) (*Config, error) {
+	if u.Hostname() == "" {
+		return nil, errors.New("ssh: missing server host")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("ssh: missing user")
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+
+	q := u.Query()
+	cfg := &Config{
+		Server:      net.JoinHostPort(u.Hostname(), port),
+		User:        u.User.Username(),
+		KeyFile:     q.Get("key"),
+		KeyData:     q.Get("key_data"),
+		Passphrase:  q.Get("passphrase"),
+		KnownHosts:  q.Get("known_hosts"),
+		Fingerprint: q.Get("fingerprint"),
+		KeepAlive:   DefaultKeepAlive,
+	}
+	cfg.Password, _ = u.User.Password()
+
+	if v := q.Get("insecure"); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: invalid insecure %q", v)
+		}
+		cfg.Insecure = insecure
+	}
+
+	if v := q.Get("keepalive"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			secs, serr := strconv.Atoi(v)
+			if serr != nil {
+				return nil, fmt.Errorf("ssh: invalid keepalive %q", v)
+			}
+			d = time.Duration(secs) * time.Second
+		}
+		cfg.KeepAlive = d
+	}
+
+	if cfg.Password == "" && cfg.KeyFile == "" && cfg.KeyData == "" {
+		return nil, errors.New("ssh: no password or private key")
+	}
+
+	return cfg, nil
+}
+
+// authMethods returns the methods to offer, the private key first.
+func (cfg *Config) authMethods() ([]gossh.AuthMethod, error) {
+	var methods []gossh.AuthMethod
+
+	pemBytes, err := cfg.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	if pemBytes != nil {
+		var signer gossh.Signer
+		if cfg.Passphrase != "" {
+			signer, err = gossh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(cfg.Passphrase))
+		} else {
+			signer, err = gossh.ParsePrivateKey(pemBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ssh: invalid private key: %w", err)
+		}
+		methods = append(methods, gossh.PublicKeys(signer))
+	}
+
+	if cfg.Password != "" {
+		methods = append(methods, gossh.Password(cfg.Password))
+	}
+
+	return methods, nil
+}
+
+func (cfg *Config) privateKey() ([]byte, error) {
+	switch {
+	case cfg.KeyData != "":
+		if strings.Contains(cfg.KeyData, "-----BEGIN") {
+			return []byte(cfg.KeyData), nil
+		}
+		b, err := base64.StdEncoding.DecodeString(cfg.KeyData)
+		if err != nil {
+			b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(cfg.KeyData, "="))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ssh: key_data is neither PEM nor base64: %w", err)
+		}
+		return b, nil
+	case cfg.KeyFile != "":
+		b, err := os.ReadFile(expandHome(cfg.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("ssh: %w", err)
+		}
+		return b, nil
+	default:
+		return nil, nil
+	}
+}
+
+// hostKeyCallback verifies the server's key by fingerprint when one is
+// given and against known_hosts otherwise.
+func (cfg *Config) hostKeyCallback() (gossh.HostKeyCallback, error) {
+	switch {
+	case cfg.Insecure:
+		return gossh.InsecureIgnoreHostKey(), nil //nolint: gosec
+	case cfg.Fingerprint != "":
+		want := cfg.Fingerprint
+		if !strings.HasPrefix(want, "SHA256:") {
+			want = "SHA256:" + want
+		}
+		return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			if got := gossh.FingerprintSHA256(key); got != want {
+				return fmt.Errorf("ssh: host key fingerprint %s does not match %s", got, want)
+			}
+			return nil
+		}, nil
+	}
+
+	file := cfg.KnownHosts
+	if file == "" {
+		file = "~/.ssh/known_hosts"
+	}
+
+	cb, err := knownhosts.New(expandHome(file))
+	if err != nil {
+		return nil, fmt.Errorf("ssh: known_hosts: %w", err)
+	}
+	return cb, nil
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
@@ -0,0 +1,299 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cnlangzi/proxyclient"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func init() {
+	proxyclient.RegisterProxy("ssh", ProxySSH)
+	proxyclient.RegisterDialer("ssh", SSHDialer)
+	proxyclient.RegisterCapabilities("ssh", proxyclient.SchemeCapabilities{Package: "ssh", Network: "tcp", TCP: true, RemoteDNS: true, Auth: true, Multiplex: true})
+}
+
+// SSHDialer creates a dialer that opens direct-tcpip channels, as
+// ssh -D does, over one SSH connection to the server in u. The connection
+// is made on first use, kept alive and shared by every later dial; it is
+// re-established when it drops.
+func SSHDialer(u *url.URL, o *proxyclient.Options) (proxyclient.ContextDialer, error) {
+	cfg, err := ParseConfig(u)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", proxyclient.ErrConfigUnsupported, err)
+	}
+
+	auth, err := cfg.authMethods()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", proxyclient.ErrConfigUnsupported, err)
+	}
+
+	hostKey, err := cfg.hostKeyCallback()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", proxyclient.ErrConfigUnsupported, err)
+	}
+
+	return &dialer{
+		cfg: cfg,
+		clientConfig: &gossh.ClientConfig{
+			User:            cfg.User,
+			Auth:            auth,
+			HostKeyCallback: hostKey,
+		},
+		forward: o.ForwardDialer(),
+	}, nil
+}
+
+func ProxySSH(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
+	d, err := SSHDialer(u, o)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := proxyclient.CreateDialerTransport(d, o)
+
+	return proxyclient.ClosableTransport(tr, d), nil
+}
+
+// dialer opens channels over a shared SSH connection.
+type dialer struct {
+	cfg          *Config
+	clientConfig *gossh.ClientConfig
+	forward      proxyclient.ContextDialer
+
+	mu         sync.Mutex
+	client     *gossh.Client
+	connecting *handshakeCall
+	closed     bool
+}
+
+// handshakeCall is a handshake in progress, shared by the dials waiting for
+// the connection. done is closed once client or err is set.
+type handshakeCall struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	client *gossh.Client
+	err    error
+	// retry is set when the handshake ended because the context of the
+	// dial that started it did, which says nothing about the server.
+	retry bool
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("ssh: network %s: %w", network, proxyclient.ErrConfigUnsupported)
+	}
+
+	client, reused, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, "tcp", addr)
+
+	// a shared connection may have died since it was last used; try once
+	// more on a fresh one
+	var openErr *gossh.OpenChannelError
+	if err != nil && reused && ctx.Err() == nil && !errors.As(err, &openErr) {
+		d.drop(client)
+		if client, _, err = d.connect(ctx); err != nil {
+			return nil, err
+		}
+		conn, err = client.DialContext(ctx, "tcp", addr)
+	}
+
+	if err != nil {
+		if errors.As(err, &openErr) {
+			return nil, fmt.Errorf("%w: ssh: %s: %w", proxyclient.ErrTargetUnreachable, addr, err)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		d.drop(client)
+		return nil, fmt.Errorf("%w: ssh: %w", proxyclient.ErrProxyUnreachable, err)
+	}
+
+	return conn, nil
+}
+
+// Close closes the SSH connection and aborts a handshake in progress.
+// Channels opened over it are closed too, and the dialer cannot be used
+// afterwards.
+func (d *dialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	if d.connecting != nil {
+		d.connecting.cancel()
+	}
+	if d.client == nil {
+		return nil
+	}
+
+	err := d.client.Close()
+	d.client = nil
+	return err
+}
+
+// connect returns the shared connection, making it if there is none. reused
+// reports whether it was made by an earlier call. Concurrent calls share one
+// handshake, which runs without the lock held so that Close can abort it.
+func (d *dialer) connect(ctx context.Context) (client *gossh.Client, reused bool, err error) {
+	for {
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			return nil, false, fmt.Errorf("ssh: %w", net.ErrClosed)
+		}
+		if d.client != nil {
+			client = d.client
+			d.mu.Unlock()
+			return client, true, nil
+		}
+
+		if call := d.connecting; call != nil {
+			d.mu.Unlock()
+
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			}
+			if call.retry {
+				continue
+			}
+			if call.err != nil {
+				return nil, false, call.err
+			}
+			return call.client, false, nil
+		}
+
+		hctx, cancel := context.WithCancel(ctx)
+		call := &handshakeCall{done: make(chan struct{}), cancel: cancel}
+		d.connecting = call
+		d.mu.Unlock()
+
+		client, err = d.handshake(hctx)
+		cancel()
+
+		d.mu.Lock()
+		d.connecting = nil
+		switch {
+		case d.closed:
+			if client != nil {
+				client.Close() //nolint: errcheck
+			}
+			client, err = nil, fmt.Errorf("ssh: %w", net.ErrClosed)
+		case err != nil:
+			call.retry = ctx.Err() != nil
+		default:
+			d.client = client
+		}
+		call.client, call.err = client, err
+		close(call.done)
+		d.mu.Unlock()
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		done := make(chan struct{})
+		go func() {
+			client.Wait() //nolint: errcheck
+			close(done)
+			d.drop(client)
+		}()
+		if d.cfg.KeepAlive > 0 {
+			go keepAlive(client, d.cfg.KeepAlive, done)
+		}
+
+		return client, false, nil
+	}
+}
+
+// drop forgets client, if it is still the shared connection, and closes it.
+func (d *dialer) drop(client *gossh.Client) {
+	d.mu.Lock()
+	if d.client == client {
+		d.client = nil
+	}
+	d.mu.Unlock()
+
+	client.Close() //nolint: errcheck
+}
+
+func (d *dialer) handshake(ctx context.Context) (*gossh.Client, error) {
+	conn, err := d.forward.DialContext(ctx, "tcp", d.cfg.Server)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: ssh: %w", proxyclient.ErrProxyUnreachable, err)
+	}
+
+	// The deadline of ctx is left to the AfterFunc, so that a timeout is
+	// always reported as ctx's and never as a failed handshake.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close() //nolint: errcheck
+	})
+
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, d.cfg.Server, d.clientConfig)
+	if !stop() || ctx.Err() != nil {
+		conn.Close() //nolint: errcheck
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close() //nolint: errcheck
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, fmt.Errorf("%w: %w", proxyclient.ErrProxyAuthFailed, err)
+		}
+		return nil, fmt.Errorf("%w: %w", proxyclient.ErrHandshakeFailed, err)
+	}
+
+	return gossh.NewClient(sshConn, chans, reqs), nil
+}
+
+// keepAlive probes client every interval and closes it when the server
+// does not answer within the next interval, until done is closed.
+func keepAlive(client *gossh.Client, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case <-done:
+			return
+		case err := <-reply:
+			if err != nil {
+				client.Close() //nolint: errcheck
+				return
+			}
+		case <-time.After(interval):
+			client.Close() //nolint: errcheck
+			return
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an SSH server that accepts password "secret" and the key
+// it was given, and serves direct-tcpip channels.
+type testServer struct {
+	net.Listener
+	hostKey gossh.Signer
+	// conns counts the SSH connections made to the server.
+	conns atomic.Int64
+}
+
+func startServer(t *testing.T, clientKey gossh.PublicKey) *testServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := gossh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	config := &gossh.ServerConfig{
+		PasswordCallback: func(c gossh.ConnMetadata, pass []byte) (*gossh.Permissions, error) {
+			if c.User() == "alice" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", c.User())
+		},
+		PublicKeyCallback: func(c gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if clientKey != nil && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %s", c.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testServer{Listener: ln, hostKey: hostKey}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn, config *gossh.ServerConfig) {
+	sshConn, chans, reqs, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close() //nolint: errcheck
+		return
+	}
+	defer sshConn.Close() //nolint: errcheck
+	s.conns.Add(1)
+
+	go func() {
+		for req := range reqs {
+			if req.WantReply {
+				req.Reply(req.Type == "keepalive@openssh.com", nil) //nolint: errcheck
+			}
+		}
+	}()
+
+	for ch := range chans {
+		if ch.ChannelType() != "direct-tcpip" {
+			ch.Reject(gossh.UnknownChannelType, "unsupported") //nolint: errcheck
+			continue
+		}
+
+		// host, port, originator host, originator port
+		extra := ch.ExtraData()
+		n := binary.BigEndian.Uint32(extra)
+		host := string(extra[4 : 4+n])
+		port := binary.BigEndian.Uint32(extra[4+n:])
+
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			ch.Reject(gossh.ConnectionFailed, err.Error()) //nolint: errcheck
+			continue
+		}
+
+		channel, chReqs, err := ch.Accept()
+		if err != nil {
+			target.Close() //nolint: errcheck
+			continue
+		}
+		go gossh.DiscardRequests(chReqs)
+		go func() {
+			defer channel.Close()       //nolint: errcheck
+			defer target.Close()        //nolint: errcheck
+			go io.Copy(target, channel) //nolint: errcheck
+			io.Copy(channel, target)    //nolint: errcheck
+		}()
+	}
+}
+
+func (s *testServer) fingerprint() string {
+	return gossh.FingerprintSHA256(s.hostKey.PublicKey())
+}
+
+func TestSSHDialer(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello") //nolint: errcheck
+	}))
+	defer web.Close()
+
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSigner, err := gossh.NewSignerFromKey(clientPriv)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKey(clientPriv, "")
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(block)
+
+	server := startServer(t, clientSigner.PublicKey())
+	defer server.Close() //nolint: errcheck
+
+	get := func(proxyURL string) (string, error) {
+		client, err := proxyclient.New(proxyURL)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Get(web.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close() //nolint: errcheck
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	fingerprint := url.QueryEscape(server.fingerprint())
+
+	t.Run("password", func(t *testing.T) {
+		body, err := get(fmt.Sprintf("ssh://alice:secret@%s?fingerprint=%s", server.Addr(), fingerprint))
+		require.NoError(t, err)
+		require.Equal(t, "hello", body)
+	})
+
+	t.Run("key file", func(t *testing.T) {
+		keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+		require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+
+		body, err := get(fmt.Sprintf("ssh://alice@%s?key=%s&fingerprint=%s", server.Addr(), url.QueryEscape(keyFile), fingerprint))
+		require.NoError(t, err)
+		require.Equal(t, "hello", body)
+	})
+
+	t.Run("inline key", func(t *testing.T) {
+		body, err := get(fmt.Sprintf("ssh://alice@%s?key_data=%s&fingerprint=%s", server.Addr(), url.QueryEscape(string(keyPEM)), fingerprint))
+		require.NoError(t, err)
+		require.Equal(t, "hello", body)
+	})
+
+	t.Run("known_hosts", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "known_hosts")
+		line := knownhosts.Line([]string{knownhosts.Normalize(server.Addr().String())}, server.hostKey.PublicKey())
+		require.NoError(t, os.WriteFile(file, []byte(line+"\n"), 0o600))
+
+		body, err := get(fmt.Sprintf("ssh://alice:secret@%s?known_hosts=%s", server.Addr(), url.QueryEscape(file)))
+		require.NoError(t, err)
+		require.Equal(t, "hello", body)
+
+		empty := filepath.Join(t.TempDir(), "known_hosts")
+		require.NoError(t, os.WriteFile(empty, nil, 0o600))
+		_, err = get(fmt.Sprintf("ssh://alice:secret@%s?known_hosts=%s", server.Addr(), url.QueryEscape(empty)))
+		require.ErrorIs(t, err, proxyclient.ErrHandshakeFailed)
+	})
+
+	t.Run("fingerprint mismatch", func(t *testing.T) {
+		_, err := get(fmt.Sprintf("ssh://alice:secret@%s?fingerprint=SHA256:AAAA", server.Addr()))
+		require.ErrorIs(t, err, proxyclient.ErrHandshakeFailed)
+	})
+
+	t.Run("auth failed", func(t *testing.T) {
+		_, err := get(fmt.Sprintf("ssh://alice:wrong@%s?insecure=true", server.Addr()))
+		require.ErrorIs(t, err, proxyclient.ErrProxyAuthFailed)
+	})
+
+	t.Run("target unreachable", func(t *testing.T) {
+		d, err := proxyclient.NewDialer(fmt.Sprintf("ssh://alice:secret@%s?insecure=1", server.Addr()))
+		require.NoError(t, err)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closed := ln.Addr().String()
+		ln.Close() //nolint: errcheck
+
+		_, err = d.DialContext(context.Background(), "tcp", closed)
+		require.ErrorIs(t, err, proxyclient.ErrTargetUnreachable)
+	})
+
+	t.Run("reuse", func(t *testing.T) {
+		d, err := proxyclient.NewDialer(fmt.Sprintf("ssh://alice:secret@%s?insecure=1", server.Addr()))
+		require.NoError(t, err)
+		defer d.(io.Closer).Close() //nolint: errcheck
+
+		before := server.conns.Load()
+		addr := web.Listener.Addr().String()
+		for range 3 {
+			conn, err := d.DialContext(context.Background(), "tcp", addr)
+			require.NoError(t, err)
+			conn.Close() //nolint: errcheck
+		}
+		require.Equal(t, before+1, server.conns.Load())
+
+		// a dropped connection is replaced on the next dial
+		d.(*dialer).mu.Lock()
+		d.(*dialer).client.Close() //nolint: errcheck
+		d.(*dialer).mu.Unlock()
+
+		conn, err := d.DialContext(context.Background(), "tcp", addr)
+		require.NoError(t, err)
+		conn.Close() //nolint: errcheck
+		require.Equal(t, before+2, server.conns.Load())
+	})
+
+	t.Run("concurrent dials", func(t *testing.T) {
+		d, err := proxyclient.NewDialer(fmt.Sprintf("ssh://alice:secret@%s?insecure=1", server.Addr()))
+		require.NoError(t, err)
+		defer d.(io.Closer).Close() //nolint: errcheck
+
+		before := server.conns.Load()
+		addr := web.Listener.Addr().String()
+		errs := make(chan error, 8)
+		for range 8 {
+			go func() {
+				conn, err := d.DialContext(context.Background(), "tcp", addr)
+				if err == nil {
+					conn.Close() //nolint: errcheck
+				}
+				errs <- err
+			}()
+		}
+		for range 8 {
+			require.NoError(t, <-errs)
+		}
+		require.Equal(t, before+1, server.conns.Load())
+	})
+
+	t.Run("close aborts handshake", func(t *testing.T) {
+		// a server that accepts and never answers
+		silent, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer silent.Close() //nolint: errcheck
+
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := silent.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+
+		d, err := proxyclient.NewDialer(fmt.Sprintf("ssh://alice:secret@%s?insecure=1", silent.Addr()))
+		require.NoError(t, err)
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := d.DialContext(context.Background(), "tcp", web.Listener.Addr().String())
+			errs <- err
+		}()
+
+		conn := <-accepted
+		defer conn.Close() //nolint: errcheck
+
+		require.NoError(t, d.(io.Closer).Close())
+		select {
+		case err := <-errs:
+			require.ErrorIs(t, err, net.ErrClosed)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not abort the handshake")
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		silent, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer silent.Close() //nolint: errcheck
+
+		go func() {
+			for {
+				conn, err := silent.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close() //nolint: errcheck
+			}
+		}()
+
+		d, err := proxyclient.NewDialer(fmt.Sprintf("ssh://alice:secret@%s?insecure=1", silent.Addr()))
+		require.NoError(t, err)
+		defer d.(io.Closer).Close() //nolint: errcheck
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err = d.DialContext(ctx, "tcp", web.Listener.Addr().String())
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Nil(t, proxyclient.Classify(err))
+	})
+
+	t.Run("config", func(t *testing.T) {
+		_, err := proxyclient.NewDialer(fmt.Sprintf("ssh://alice@%s", server.Addr()))
+		require.ErrorIs(t, err, proxyclient.ErrConfigUnsupported)
+
+		require.True(t, proxyclient.Capabilities("ssh").Registered)
+	})
+}
+
+func TestParseConfig(t *testing.T) {
+	u, _ := url.Parse("ssh://bob:pw@bastion.example.com?keepalive=10s&insecure=true")
+	cfg, err := ParseConfig(u)
+	require.NoError(t, err)
+	require.Equal(t, "bastion.example.com:22", cfg.Server)
+	require.Equal(t, "bob", cfg.User)
+	require.Equal(t, "pw", cfg.Password)
+	require.True(t, cfg.Insecure)
+	require.Equal(t, "10s", cfg.KeepAlive.String())
+
+	u, _ = url.Parse("ssh://bob:pw@bastion.example.com:2222?keepalive=0")
+	cfg, err = ParseConfig(u)
+	require.NoError(t, err)
+	require.Equal(t, "bastion.example.com:2222", cfg.Server)
+	require.Zero(t, cfg.KeepAlive)
+}
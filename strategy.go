@@ -0,0 +1,176 @@
+package proxyclient
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Strategy picks the backend a Pool sends a request through. Strategies
+// may keep state, so an instance belongs to a single Pool.
+type Strategy interface {
+	Pick(req *http.Request, backends []*Backend) *Backend
+}
+
+// StrategyFunc adapts a function to Strategy.
+type StrategyFunc func(req *http.Request, backends []*Backend) *Backend
+
+func (f StrategyFunc) Pick(req *http.Request, backends []*Backend) *Backend {
+	return f(req, backends)
+}
+
+// RoundRobin cycles through the backends in order.
+func RoundRobin() Strategy {
+	var next atomic.Uint64
+	return StrategyFunc(func(_ *http.Request, backends []*Backend) *Backend {
+		if len(backends) == 0 {
+			return nil
+		}
+		n := next.Add(1) - 1
+		return backends[n%uint64(len(backends))]
+	})
+}
+
+// Random picks a backend uniformly at random.
+func Random() Strategy {
+	return StrategyFunc(func(_ *http.Request, backends []*Backend) *Backend {
+		if len(backends) == 0 {
+			return nil
+		}
+		return backends[rand.IntN(len(backends))]
+	})
+}
+
+// LeastInFlight picks the backend with the fewest requests in flight,
+// preferring the earlier backend on ties.
+func LeastInFlight() Strategy {
+	return StrategyFunc(func(_ *http.Request, backends []*Backend) *Backend {
+		var best *Backend
+		for _, b := range backends {
+			if best == nil || b.InFlight() < best.InFlight() {
+				best = b
+			}
+		}
+		return best
+	})
+}
+
+// Weighted spreads requests in proportion to weights, which follow the
+// order of the pool's URLs. Missing or non-positive weights count as 1.
+// It uses smooth weighted round-robin, so a heavy backend is interleaved
+// with the others instead of receiving its share in one burst.
+func Weighted(weights ...int) Strategy {
+	return &weighted{weights: weights}
+}
+
+type weighted struct {
+	mu      sync.Mutex
+	weights []int
+	current []int
+	// backends is the list current was kept for.
+	backends []*Backend
+}
+
+func (s *weighted) weight(i int) int {
+	if i < len(s.weights) && s.weights[i] > 0 {
+		return s.weights[i]
+	}
+	return 1
+}
+
+func (s *weighted) Pick(_ *http.Request, backends []*Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.Equal(s.backends, backends) {
+		s.backends = slices.Clone(backends)
+		s.current = make([]int, len(backends))
+	}
+
+	total, best := 0, 0
+	for i := range backends {
+		w := s.weight(i)
+		s.current[i] += w
+		total += w
+		if s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+	s.current[best] -= total
+
+	return backends[best]
+}
+
+// consistentHashReplicas is the number of points each backend owns on the
+// hash ring. More points give a more even spread.
+const consistentHashReplicas = 160
+
+// ConsistentHash sends every request for the same target host through the
+// same backend, and moves as few hosts as possible when the backend list
+// differs.
+func ConsistentHash() Strategy {
+	return &consistentHash{}
+}
+
+type consistentHash struct {
+	mu sync.Mutex
+	// backends is the list the ring was built for.
+	backends []*Backend
+	points   []uint32
+	owners   map[uint32]int
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s)) //nolint: errcheck
+	return h.Sum32()
+}
+
+func (s *consistentHash) build(backends []*Backend) {
+	s.backends = slices.Clone(backends)
+	s.points = make([]uint32, 0, len(backends)*consistentHashReplicas)
+	s.owners = make(map[uint32]int, len(backends)*consistentHashReplicas)
+
+	for i, b := range backends {
+		for r := 0; r < consistentHashReplicas; r++ {
+			p := hashKey(b.URL + "#" + strconv.Itoa(r))
+			if _, ok := s.owners[p]; ok {
+				continue
+			}
+			s.owners[p] = i
+			s.points = append(s.points, p)
+		}
+	}
+
+	sort.Slice(s.points, func(i, j int) bool { return s.points[i] < s.points[j] })
+}
+
+func (s *consistentHash) Pick(req *http.Request, backends []*Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.Equal(s.backends, backends) {
+		s.build(backends)
+	}
+
+	h := hashKey(req.URL.Hostname())
+	i := sort.Search(len(s.points), func(i int) bool { return s.points[i] >= h })
+	if i == len(s.points) {
+		i = 0
+	}
+
+	return backends[s.owners[s.points[i]]]
+}
@@ -37,3 +37,8 @@ func (i *String) UnmarshalJSON(data []byte) error {
 func (i String) Value() string {
 	return i.v
 }
+
+// NewString returns a String holding v.
+func NewString(v string) String {
+	return String{v: v}
+}
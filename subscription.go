@@ -0,0 +1,203 @@
+package proxyclient
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrEmptySubscription = errors.New("proxyclient: empty subscription")
+
+// SubscriptionError reports a subscription entry that could not be parsed.
+// Line is the 1-based line of the decoded list, or the 1-based position of
+// the server for SIP008 JSON.
+type SubscriptionError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *SubscriptionError) Error() string {
+	return fmt.Sprintf("subscription line %d: %v", e.Line, e.Err)
+}
+
+func (e *SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+// ParseSubscription reads a subscription and parses every share link in it
+// with the parsers of DefaultRegistry. It accepts:
+//
+//   - plain text, one share link per line
+//   - the same list base64 encoded (standard or URL-safe, padded or not)
+//   - SIP008 JSON for shadowsocks
+//
+// A bad entry does not abort the subscription; its error is collected and
+// the remaining entries are still parsed. Blank lines and lines starting
+// with '#' are skipped.
+func ParseSubscription(r io.Reader) ([]URL, []error) {
+	return DefaultRegistry.ParseSubscription(r)
+}
+
+// ParseSubscription is ParseSubscription with the parsers of reg.
+func (reg *Registry) ParseSubscription(r io.Reader) ([]URL, []error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	text := strings.TrimSpace(strings.TrimPrefix(string(data), "\ufeff"))
+	if text == "" {
+		return nil, []error{ErrEmptySubscription}
+	}
+
+	// A base64 blob never contains "://", a link list always does.
+	if !strings.Contains(text, "://") {
+		if decoded, ok := decodeSubscription(text); ok {
+			text = strings.TrimSpace(decoded)
+		}
+	}
+
+	if strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") {
+		if servers, err := parseSIP008(text); err == nil {
+			return parseSIP008Servers(reg, servers)
+		}
+	}
+
+	return parseSubscriptionLines(reg, text)
+}
+
+// decodeSubscription decodes a base64 subscription blob. Providers wrap long
+// blobs across lines and disagree on the alphabet and padding, so every
+// variant is tried.
+func decodeSubscription(text string) (string, bool) {
+	blob := strings.Join(strings.Fields(text), "")
+
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.RawStdEncoding,
+		base64.URLEncoding,
+		base64.RawURLEncoding,
+	} {
+		if decoded, err := enc.DecodeString(blob); err == nil {
+			return string(decoded), true
+		}
+	}
+
+	return "", false
+}
+
+func parseSubscriptionLines(r *Registry, text string) ([]URL, []error) {
+	var (
+		items []URL
+		errs  []error
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		u, err := r.ParseURL(line)
+		if err != nil {
+			errs = append(errs, &SubscriptionError{Line: n, Text: line, Err: err})
+			continue
+		}
+		items = append(items, u)
+	}
+
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return items, errs
+}
+
+// sip008Server is a server entry of a SIP008 online configuration.
+type sip008Server struct {
+	ID         string `json:"id"`
+	Remarks    string `json:"remarks"`
+	Server     string `json:"server"`
+	ServerPort Int    `json:"server_port"`
+	Password   string `json:"password"`
+	Method     string `json:"method"`
+	Plugin     string `json:"plugin"`
+	PluginOpts string `json:"plugin_opts"`
+}
+
+// parseSIP008 accepts a SIP008 document, or the bare servers array some
+// providers serve instead.
+func parseSIP008(text string) ([]sip008Server, error) {
+	if strings.HasPrefix(text, "[") {
+		var servers []sip008Server
+		if err := json.Unmarshal([]byte(text), &servers); err != nil {
+			return nil, err
+		}
+		return servers, nil
+	}
+
+	var doc struct {
+		Version int            `json:"version"`
+		Servers []sip008Server `json:"servers"`
+	}
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, err
+	}
+	if doc.Servers == nil {
+		return nil, errors.New("proxyclient: not a SIP008 document")
+	}
+	return doc.Servers, nil
+}
+
+func parseSIP008Servers(r *Registry, servers []sip008Server) ([]URL, []error) {
+	var (
+		items []URL
+		errs  []error
+	)
+
+	for i, s := range servers {
+		link := s.shareLink()
+
+		u, err := r.ParseURL(link)
+		if err != nil {
+			errs = append(errs, &SubscriptionError{Line: i + 1, Text: link, Err: err})
+			continue
+		}
+		items = append(items, u)
+	}
+
+	return items, errs
+}
+
+// shareLink converts the entry into a SIP002 ss:// link.
+func (s sip008Server) shareLink() string {
+	userInfo := base64.RawURLEncoding.EncodeToString([]byte(s.Method + ":" + s.Password))
+	link := "ss://" + userInfo + "@" + net.JoinHostPort(s.Server, strconv.Itoa(s.ServerPort.Value()))
+
+	if s.Plugin != "" {
+		plugin := s.Plugin
+		if s.PluginOpts != "" {
+			plugin += ";" + s.PluginOpts
+		}
+		link += "/?plugin=" + url.QueryEscape(plugin)
+	}
+
+	if s.Remarks != "" {
+		link += "#" + url.PathEscape(s.Remarks)
+	}
+
+	return link
+}
@@ -11,10 +11,9 @@ import (
 
 type FuncParser func(u *url.URL) (URL, error)
 
-var parsers = make(map[string]FuncParser)
-
+// RegisterParser registers the parser of proto in DefaultRegistry.
 func RegisterParser(proto string, f FuncParser) {
-	parsers[proto] = f
+	DefaultRegistry.RegisterParser(proto, f)
 }
 
 type URL interface {
@@ -26,6 +25,9 @@ type URL interface {
 	User() string
 	Password() string
 	Name() string
+	// Encode returns the canonical share link of the URL. Parsing it yields
+	// the same configuration, including any edits made after parsing.
+	Encode() string
 }
 
 type stdURL struct {
@@ -68,22 +70,13 @@ func (u *stdURL) Name() string {
 	return u.Fragment
 }
 
-func ParseURL(u string) (URL, error) {
-	parsedURL, err := url.Parse(u)
-	if err != nil {
-		return nil, err
-	}
-
-	parser, ok := parsers[parsedURL.Scheme]
-	if ok {
-		return parser(parsedURL)
-	}
-
-	if IsHost(parsedURL.Hostname()) {
-		return &stdURL{*parsedURL}, nil
-	}
+func (u *stdURL) Encode() string {
+	return u.URL.String()
+}
 
-	return nil, ErrInvalidHost
+// ParseURL parses u with the parsers of DefaultRegistry.
+func ParseURL(u string) (URL, error) {
+	return DefaultRegistry.ParseURL(u)
 }
 
 func IsHost(s string) bool {
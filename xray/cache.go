@@ -0,0 +1,226 @@
+package xray
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InstanceMemory and OutboundMemory are the memory a running xray instance
+// and an outbound of the shared core are assumed to take, used for the
+// MemoryEstimate of CacheStats. The defaults are rough figures; calibrate
+// them against a heap profile of the workload if the estimate matters.
+var (
+	InstanceMemory int64 = 4 << 20
+	OutboundMemory int64 = 256 << 10
+)
+
+// CacheStats is a snapshot of the xray instances of the process.
+type CacheStats struct {
+	// Live is the number of cached instances, the ones new dialers share.
+	Live int
+	// Draining is the number of instances closed or evicted from the cache
+	// that still run for the dialers and connections using them.
+	Draining int
+	// Starting is the number of instances waiting for a start slot or
+	// starting.
+	Starting int
+	// Outbounds is the number of live and draining proxies that are
+	// outbounds of the shared core rather than instances of their own.
+	Outbounds int
+
+	Starts        int64
+	StartFailures int64
+	Evictions     int64
+
+	// LastStartLatency, AvgStartLatency and MaxStartLatency measure the
+	// successful starts, without the time spent waiting for a start slot.
+	LastStartLatency time.Duration
+	AvgStartLatency  time.Duration
+	MaxStartLatency  time.Duration
+
+	// MemoryEstimate is InstanceMemory bytes for every instance, the shared
+	// core included, plus OutboundMemory bytes for every outbound on it.
+	MemoryEstimate int64
+}
+
+var (
+	// lruMu guards lru and maxInstances. It is taken after a shard lock,
+	// never before one.
+	lruMu        sync.Mutex
+	lru          = list.New()
+	maxInstances int
+
+	stats struct {
+		draining      atomic.Int64
+		starting      atomic.Int64
+		starts        atomic.Int64
+		startFailures atomic.Int64
+		evictions     atomic.Int64
+		startTotal    atomic.Int64
+		startLast     atomic.Int64
+		startMax      atomic.Int64
+	}
+)
+
+// SetMaxInstances bounds how many instances are cached at once. When a new
+// instance would exceed it, the least recently used idle one, an instance
+// pinned by StartVmess and the like that no dialer or connection uses, is
+// evicted as by Close and so stopped at once; the next user of its URL
+// starts a fresh one. Instances in use are never evicted, since their
+// users would keep them running next to the fresh one: while every
+// instance is in use the cache exceeds n, and the least recently used one
+// is evicted as soon as it becomes idle. n <= 0, the default, leaves the
+// cache unbounded.
+func SetMaxInstances(n int) {
+	lruMu.Lock()
+	maxInstances = n
+	lruMu.Unlock()
+
+	evictOverflow()
+}
+
+// Stats returns a snapshot of the instances and their start times.
+func Stats() CacheStats {
+	lruMu.Lock()
+	live := lru.Len()
+	lruMu.Unlock()
+
+	s := CacheStats{
+		Live:             live,
+		Draining:         int(stats.draining.Load()),
+		Starting:         int(stats.starting.Load()),
+		Starts:           stats.starts.Load(),
+		StartFailures:    stats.startFailures.Load(),
+		Evictions:        stats.evictions.Load(),
+		LastStartLatency: time.Duration(stats.startLast.Load()),
+		MaxStartLatency:  time.Duration(stats.startMax.Load()),
+	}
+	if s.Starts > 0 {
+		s.AvgStartLatency = time.Duration(stats.startTotal.Load() / s.Starts)
+	}
+	outbounds, running := sharedOutbounds()
+	instances := s.Live + s.Draining - outbounds
+	if instances < 0 {
+		// the counts are read one after the other
+		instances = 0
+	}
+	if running {
+		instances++
+	}
+	s.Outbounds = outbounds
+	s.MemoryEstimate = int64(instances)*InstanceMemory + int64(outbounds)*OutboundMemory
+	return s
+}
+
+// recordStart accounts for a start that took d.
+func recordStart(d time.Duration, err error) {
+	if err != nil {
+		stats.startFailures.Add(1)
+		return
+	}
+
+	stats.starts.Add(1)
+	stats.startTotal.Add(int64(d))
+	stats.startLast.Store(int64(d))
+	for {
+		cur := stats.startMax.Load()
+		if int64(d) <= cur || stats.startMax.CompareAndSwap(cur, int64(d)) {
+			return
+		}
+	}
+}
+
+// cacheAdd puts a new server at the front of the LRU list. It must be
+// called with the shard locked.
+func cacheAdd(s *Server) {
+	lruMu.Lock()
+	s.elem = lru.PushFront(s)
+	lruMu.Unlock()
+}
+
+// cacheTouch marks s as just used. It must be called with the shard locked.
+func cacheTouch(s *Server) {
+	lruMu.Lock()
+	if s.elem != nil {
+		lru.MoveToFront(s.elem)
+	}
+	lruMu.Unlock()
+}
+
+// cacheRemove takes s off the LRU list. It must be called with the shard
+// locked.
+func cacheRemove(s *Server) {
+	lruMu.Lock()
+	if s.elem != nil {
+		lru.Remove(s.elem)
+		s.elem = nil
+	}
+	lruMu.Unlock()
+}
+
+// evictOverflow evicts the least recently used idle servers while there
+// are more than maxInstances.
+func evictOverflow() {
+	for {
+		lruMu.Lock()
+		if maxInstances <= 0 || lru.Len() <= maxInstances {
+			lruMu.Unlock()
+			return
+		}
+		// whether a server is idle can only be told under its shard lock,
+		// which must not be taken with lruMu held
+		victims := make([]*Server, 0, lru.Len())
+		for e := lru.Back(); e != nil; e = e.Prev() {
+			victims = append(victims, e.Value.(*Server))
+		}
+		lruMu.Unlock()
+
+		evicted := false
+		for _, s := range victims {
+			if evicted = evict(s); evicted {
+				break
+			}
+		}
+		if !evicted {
+			return
+		}
+	}
+}
+
+// evict retires s if it is still cached and idle, and reports whether it
+// did.
+func evict(s *Server) bool {
+	idx := hashShard(s.url)
+	shardedMu[idx].Lock()
+	if s.elem == nil || s.refs > 0 {
+		shardedMu[idx].Unlock()
+		return false
+	}
+	last := s.retire()
+	shardedMu[idx].Unlock()
+
+	stats.evictions.Add(1)
+	if last {
+		s.close()
+	}
+	return true
+}
+
+// resetCache clears the LRU list and the statistics for ResetForTest.
+func resetCache() {
+	lruMu.Lock()
+	lru.Init()
+	maxInstances = 0
+	lruMu.Unlock()
+
+	stats.draining.Store(0)
+	stats.starting.Store(0)
+	stats.starts.Store(0)
+	stats.startFailures.Store(0)
+	stats.evictions.Store(0)
+	stats.startTotal.Store(0)
+	stats.startLast.Store(0)
+	stats.startMax.Store(0)
+}
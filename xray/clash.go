@@ -0,0 +1,491 @@
+package xray
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cnlangzi/proxyclient"
+)
+
+func init() {
+	proxyclient.RegisterClashParser("vmess", func(e *proxyclient.ClashEntry) (proxyclient.URL, error) {
+		return VmessFromClash(e)
+	})
+	proxyclient.RegisterClashParser("vless", func(e *proxyclient.ClashEntry) (proxyclient.URL, error) {
+		return VlessFromClash(e)
+	})
+	proxyclient.RegisterClashParser("trojan", func(e *proxyclient.ClashEntry) (proxyclient.URL, error) {
+		return TrojanFromClash(e)
+	})
+	proxyclient.RegisterClashParser("ssr", func(e *proxyclient.ClashEntry) (proxyclient.URL, error) {
+		return SSRFromClash(e)
+	})
+
+	proxyclient.RegisterClashMarshaler("vmess", func(u proxyclient.URL) (map[string]any, error) {
+		v, ok := u.(*VmessURL)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", proxyclient.ErrUnsupportedClashType, u.Protocol())
+		}
+		return VmessToClash(v.Config)
+	})
+	proxyclient.RegisterClashMarshaler("vless", func(u proxyclient.URL) (map[string]any, error) {
+		v, ok := u.(*VlessURL)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", proxyclient.ErrUnsupportedClashType, u.Protocol())
+		}
+		return VlessToClash(v.Config)
+	})
+	proxyclient.RegisterClashMarshaler("trojan", func(u proxyclient.URL) (map[string]any, error) {
+		v, ok := u.(*TrojanURL)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", proxyclient.ErrUnsupportedClashType, u.Protocol())
+		}
+		return TrojanToClash(v.Config)
+	})
+	proxyclient.RegisterClashMarshaler("ssr", func(u proxyclient.URL) (map[string]any, error) {
+		v, ok := u.(*SSRURL)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", proxyclient.ErrUnsupportedClashType, u.Protocol())
+		}
+		return SSRToClash(v.Config)
+	})
+}
+
+// clashTransport is the transport part shared by the vmess, vless and
+// trojan Clash entries, in share-link terms.
+type clashTransport struct {
+	Net         string // tcp, ws, grpc, h2
+	Type        string // header type; "http" for HTTP obfuscation over tcp
+	Host        string
+	Path        string
+	ServiceName string
+}
+
+func parseClashTransport(e *proxyclient.ClashEntry) clashTransport {
+	t := clashTransport{Net: e.String("network")}
+
+	switch t.Net {
+	case "", "tcp":
+		t.Net = "tcp"
+	case "ws":
+		ws := e.Entry("ws-opts")
+		t.Path = ws.String("path")
+		t.Host = ws.Entry("headers").String("Host")
+	case "grpc":
+		t.ServiceName = e.Entry("grpc-opts").String("grpc-service-name")
+	case "h2":
+		h2 := e.Entry("h2-opts")
+		t.Path = h2.String("path")
+		if hosts := h2.Strings("host"); len(hosts) > 0 {
+			t.Host = hosts[0]
+		}
+	case "http":
+		// Clash calls HTTP obfuscation over plain TCP "http".
+		h := e.Entry("http-opts")
+		t.Net = "tcp"
+		t.Type = "http"
+		if paths := h.Strings("path"); len(paths) > 0 {
+			t.Path = paths[0]
+		}
+		if hosts := h.Entry("headers").Strings("Host"); len(hosts) > 0 {
+			t.Host = hosts[0]
+		}
+	}
+
+	return t
+}
+
+func (t clashTransport) marshal(p map[string]any) error {
+	switch t.Net {
+	case "", "tcp":
+		if t.Type != "http" {
+			return nil
+		}
+
+		p["network"] = "http"
+		h := map[string]any{}
+		if t.Path != "" {
+			h["path"] = []string{t.Path}
+		}
+		if t.Host != "" {
+			h["headers"] = map[string]any{"Host": []string{t.Host}}
+		}
+		setClashOpts(p, "http-opts", h)
+	case "ws":
+		p["network"] = "ws"
+		ws := map[string]any{}
+		if t.Path != "" {
+			ws["path"] = t.Path
+		}
+		if t.Host != "" {
+			ws["headers"] = map[string]any{"Host": t.Host}
+		}
+		setClashOpts(p, "ws-opts", ws)
+	case "grpc":
+		p["network"] = "grpc"
+		if t.ServiceName != "" {
+			p["grpc-opts"] = map[string]any{"grpc-service-name": t.ServiceName}
+		}
+	case "h2", "http":
+		p["network"] = "h2"
+		h2 := map[string]any{}
+		if t.Path != "" {
+			h2["path"] = t.Path
+		}
+		if t.Host != "" {
+			h2["host"] = []string{t.Host}
+		}
+		setClashOpts(p, "h2-opts", h2)
+	default:
+		return fmt.Errorf("network %q has no clash equivalent", t.Net)
+	}
+
+	return nil
+}
+
+func setClashOpts(p map[string]any, key string, opts map[string]any) {
+	if len(opts) > 0 {
+		p[key] = opts
+	}
+}
+
+func splitALPN(e *proxyclient.ClashEntry) string {
+	return strings.Join(e.Strings("alpn"), ",")
+}
+
+func setClashALPN(p map[string]any, alpn string) {
+	if alpn != "" {
+		p["alpn"] = strings.Split(alpn, ",")
+	}
+}
+
+func setClashString(p map[string]any, key, v string) {
+	if v != "" {
+		p[key] = v
+	}
+}
+
+// VmessFromClash converts a Clash vmess entry into a VmessURL.
+func VmessFromClash(e *proxyclient.ClashEntry) (*VmessURL, error) {
+	t := parseClashTransport(e)
+
+	cfg := &VmessConfig{
+		V:             proxyclient.NewInt(2),
+		PS:            e.String("name"),
+		Add:           e.String("server"),
+		Port:          proxyclient.NewInt(e.Int("port")),
+		ID:            e.String("uuid"),
+		Aid:           proxyclient.NewInt(e.Int("alterId")),
+		Security:      e.String("cipher"),
+		Net:           t.Net,
+		Type:          t.Type,
+		Host:          t.Host,
+		Path:          t.Path,
+		SNI:           e.String("servername"),
+		Alpn:          splitALPN(e),
+		Fp:            e.String("client-fingerprint"),
+		AllowInsecure: e.Bool("skip-cert-verify"),
+	}
+
+	// vmess links carry the gRPC service name in path
+	if t.Net == "grpc" {
+		cfg.Path = t.ServiceName
+	}
+
+	if e.Bool("tls") {
+		cfg.TLS = proxyclient.NewString("tls")
+	}
+
+	if e.Has("reality-opts") {
+		reality := e.Entry("reality-opts")
+		cfg.TLS = proxyclient.NewString("reality")
+		cfg.PbK = reality.String("public-key")
+		cfg.Sid = reality.String("short-id")
+	}
+
+	u := &VmessURL{Config: cfg}
+	raw, err := url.Parse(u.Encode())
+	if err != nil {
+		return nil, err
+	}
+	cfg.raw = raw
+
+	return u, nil
+}
+
+// VmessToClash converts a VmessConfig into a Clash vmess entry.
+func VmessToClash(vmess *VmessConfig) (map[string]any, error) {
+	p := map[string]any{
+		"name":    vmess.PS,
+		"type":    "vmess",
+		"server":  vmess.Add,
+		"port":    vmess.Port.Value(),
+		"uuid":    vmess.ID,
+		"alterId": vmess.Aid.Value(),
+		"cipher":  getSecurityMethod(vmess),
+	}
+
+	t := clashTransport{
+		Net:  vmess.Net,
+		Type: vmess.Type,
+		Host: vmess.Host,
+		Path: vmess.Path,
+	}
+	if vmess.Net == "grpc" {
+		t.Path = ""
+		t.ServiceName = vmess.Path
+	}
+	if err := t.marshal(p); err != nil {
+		return nil, err
+	}
+
+	switch vmess.TLS.Value() {
+	case "tls", "true":
+		p["tls"] = true
+	case "reality":
+		p["tls"] = true
+		p["reality-opts"] = map[string]any{
+			"public-key": vmess.PbK,
+			"short-id":   vmess.Sid,
+		}
+	}
+
+	setClashString(p, "servername", vmess.SNI)
+	setClashString(p, "client-fingerprint", vmess.Fp)
+	setClashALPN(p, vmess.Alpn)
+	if vmess.AllowInsecure {
+		p["skip-cert-verify"] = true
+	}
+
+	var dropped []string
+	if vmess.Flow != "" {
+		dropped = append(dropped, "flow")
+	}
+	if vmess.SpX != "" {
+		dropped = append(dropped, "spx")
+	}
+	if vmess.XHTTPVer != "" {
+		dropped = append(dropped, "xver")
+	}
+
+	return p, proxyclient.UnsupportedClashFields(dropped...)
+}
+
+// VlessFromClash converts a Clash vless entry into a VlessURL.
+func VlessFromClash(e *proxyclient.ClashEntry) (*VlessURL, error) {
+	t := parseClashTransport(e)
+
+	cfg := &VlessConfig{
+		UUID:          e.String("uuid"),
+		Address:       e.String("server"),
+		Port:          e.Int("port"),
+		Encryption:    e.String("encryption"),
+		Flow:          e.String("flow"),
+		Type:          t.Net,
+		Path:          t.Path,
+		Host:          t.Host,
+		SNI:           e.String("servername"),
+		ALPN:          splitALPN(e),
+		Fingerprint:   e.String("client-fingerprint"),
+		ServiceName:   t.ServiceName,
+		AllowInsecure: e.Bool("skip-cert-verify"),
+		Remark:        e.String("name"),
+	}
+
+	if cfg.Encryption == "" {
+		cfg.Encryption = "none"
+	}
+
+	if t.Type == "http" {
+		return nil, fmt.Errorf("vless does not support http obfuscation")
+	}
+
+	if e.Bool("tls") {
+		cfg.Security = "tls"
+	}
+
+	if e.Has("reality-opts") {
+		reality := e.Entry("reality-opts")
+		cfg.Security = "reality"
+		cfg.PublicKey = reality.String("public-key")
+		cfg.ShortID = reality.String("short-id")
+	}
+
+	u := &VlessURL{Config: cfg}
+	raw, err := url.Parse(u.Encode())
+	if err != nil {
+		return nil, err
+	}
+	cfg.raw = raw
+
+	return u, nil
+}
+
+// VlessToClash converts a VlessConfig into a Clash vless entry.
+func VlessToClash(vless *VlessConfig) (map[string]any, error) {
+	p := map[string]any{
+		"name":   vless.Remark,
+		"type":   "vless",
+		"server": vless.Address,
+		"port":   vless.Port,
+		"uuid":   vless.UUID,
+	}
+
+	t := clashTransport{
+		Net:         vless.Type,
+		Host:        vless.Host,
+		Path:        vless.Path,
+		ServiceName: vless.ServiceName,
+	}
+	if err := t.marshal(p); err != nil {
+		return nil, err
+	}
+
+	switch vless.Security {
+	case "tls":
+		p["tls"] = true
+	case "reality":
+		p["tls"] = true
+		p["reality-opts"] = map[string]any{
+			"public-key": vless.PublicKey,
+			"short-id":   vless.ShortID,
+		}
+	}
+
+	if vless.Encryption != "none" {
+		setClashString(p, "encryption", vless.Encryption)
+	}
+	setClashString(p, "flow", vless.Flow)
+	setClashString(p, "servername", vless.SNI)
+	setClashString(p, "client-fingerprint", vless.Fingerprint)
+	setClashALPN(p, vless.ALPN)
+	if vless.AllowInsecure {
+		p["skip-cert-verify"] = true
+	}
+
+	if vless.SpiderX != "" {
+		return p, proxyclient.UnsupportedClashFields("spx")
+	}
+
+	return p, nil
+}
+
+// TrojanFromClash converts a Clash trojan entry into a TrojanURL.
+func TrojanFromClash(e *proxyclient.ClashEntry) (*TrojanURL, error) {
+	t := parseClashTransport(e)
+
+	cfg := &TrojanConfig{
+		Password:      e.String("password"),
+		Address:       e.String("server"),
+		Port:          e.Int("port"),
+		Type:          t.Net,
+		Security:      "tls",
+		Path:          t.Path,
+		Host:          t.Host,
+		SNI:           e.String("sni"),
+		ALPN:          splitALPN(e),
+		Fingerprint:   e.String("client-fingerprint"),
+		ServiceName:   t.ServiceName,
+		AllowInsecure: e.Bool("skip-cert-verify"),
+		Remark:        e.String("name"),
+	}
+
+	if t.Type == "http" {
+		return nil, fmt.Errorf("trojan does not support http obfuscation")
+	}
+
+	if cfg.SNI == "" {
+		cfg.SNI = cfg.Address
+	}
+
+	u := &TrojanURL{Config: cfg}
+	raw, err := url.Parse(u.Encode())
+	if err != nil {
+		return nil, err
+	}
+	cfg.raw = raw
+
+	return u, nil
+}
+
+// TrojanToClash converts a TrojanConfig into a Clash trojan entry.
+func TrojanToClash(trojan *TrojanConfig) (map[string]any, error) {
+	if trojan.Security != "" && trojan.Security != "tls" {
+		return nil, fmt.Errorf("trojan security %q has no clash equivalent", trojan.Security)
+	}
+
+	p := map[string]any{
+		"name":     trojan.Remark,
+		"type":     "trojan",
+		"server":   trojan.Address,
+		"port":     trojan.Port,
+		"password": trojan.Password,
+	}
+
+	t := clashTransport{
+		Net:         trojan.Type,
+		Host:        trojan.Host,
+		Path:        trojan.Path,
+		ServiceName: trojan.ServiceName,
+	}
+	if err := t.marshal(p); err != nil {
+		return nil, err
+	}
+
+	setClashString(p, "sni", trojan.SNI)
+	setClashString(p, "client-fingerprint", trojan.Fingerprint)
+	setClashALPN(p, trojan.ALPN)
+	if trojan.AllowInsecure {
+		p["skip-cert-verify"] = true
+	}
+
+	if trojan.Flow != "" {
+		return p, proxyclient.UnsupportedClashFields("flow")
+	}
+
+	return p, nil
+}
+
+// SSRFromClash converts a Clash ssr entry into an SSRURL.
+func SSRFromClash(e *proxyclient.ClashEntry) (*SSRURL, error) {
+	cfg := &SSRConfig{
+		Server:        e.String("server"),
+		Port:          e.Int("port"),
+		Method:        e.String("cipher"),
+		Password:      e.String("password"),
+		Protocol:      e.String("protocol"),
+		ProtocolParam: e.String("protocol-param"),
+		Obfs:          e.String("obfs"),
+		ObfsParam:     e.String("obfs-param"),
+		Name:          e.String("name"),
+	}
+
+	u := &SSRURL{Config: cfg}
+	raw, err := url.Parse(u.Encode())
+	if err != nil {
+		return nil, err
+	}
+	cfg.raw = raw
+
+	return u, nil
+}
+
+// SSRToClash converts an SSRConfig into a Clash ssr entry.
+func SSRToClash(ssr *SSRConfig) (map[string]any, error) {
+	p := map[string]any{
+		"name":     ssr.Name,
+		"type":     "ssr",
+		"server":   ssr.Server,
+		"port":     ssr.Port,
+		"cipher":   ssr.Method,
+		"password": ssr.Password,
+		"protocol": ssr.Protocol,
+		"obfs":     ssr.Obfs,
+	}
+
+	setClashString(p, "protocol-param", ssr.ProtocolParam)
+	setClashString(p, "obfs-param", ssr.ObfsParam)
+
+	return p, nil
+}
@@ -0,0 +1,191 @@
+package xray
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/stretchr/testify/require"
+)
+
+const clashConfig = `
+proxies:
+  - name: vmess-ws
+    type: vmess
+    server: vmess.example.com
+    port: 443
+    uuid: b831381d-6324-4d53-ad4f-8cda48b30811
+    alterId: 0
+    cipher: auto
+    tls: true
+    servername: cdn.example.com
+    network: ws
+    ws-opts:
+      path: /ray
+      headers:
+        Host: cdn.example.com
+  - name: vmess-http
+    type: vmess
+    server: 1.2.3.4
+    port: 80
+    uuid: b831381d-6324-4d53-ad4f-8cda48b30811
+    alterId: 64
+    cipher: aes-128-gcm
+    network: http
+    http-opts:
+      method: GET
+      path: [/index]
+      headers:
+        Host: [www.example.com]
+  - name: vless-reality
+    type: vless
+    server: vless.example.com
+    port: 443
+    uuid: b831381d-6324-4d53-ad4f-8cda48b30811
+    flow: xtls-rprx-vision
+    tls: true
+    servername: www.microsoft.com
+    client-fingerprint: chrome
+    reality-opts:
+      public-key: pubkey
+      short-id: "6ba85179e30d4fc2"
+  - name: trojan-grpc
+    type: trojan
+    server: trojan.example.com
+    port: 443
+    password: secret
+    sni: sni.example.com
+    skip-cert-verify: true
+    alpn: [h2]
+    network: grpc
+    grpc-opts:
+      grpc-service-name: tunnel
+  - name: ssr
+    type: ssr
+    server: ssr.example.com
+    port: 8388
+    cipher: aes-256-cfb
+    password: secret
+    obfs: tls1.2_ticket_auth
+    obfs-param: download.windowsupdate.com
+    protocol: auth_aes128_md5
+    protocol-param: "1:abc"
+`
+
+func TestParseClash(t *testing.T) {
+	items, errs := proxyclient.ParseClash(strings.NewReader(clashConfig))
+	require.Len(t, items, 5)
+
+	// http-opts.method cannot be expressed in a share link
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], proxyclient.ErrUnsupportedClashField)
+	require.Contains(t, errs[0].Error(), "http-opts.method")
+
+	vmess := items[0].(*VmessURL).Config
+	require.Equal(t, "vmess-ws", vmess.PS)
+	require.Equal(t, "vmess.example.com", vmess.Add)
+	require.Equal(t, 443, vmess.Port.Value())
+	require.Equal(t, "ws", vmess.Net)
+	require.Equal(t, "/ray", vmess.Path)
+	require.Equal(t, "cdn.example.com", vmess.Host)
+	require.Equal(t, "tls", vmess.TLS.Value())
+	require.Equal(t, "cdn.example.com", vmess.SNI)
+	require.False(t, vmess.AllowInsecure)
+
+	vmess = items[1].(*VmessURL).Config
+	require.Equal(t, "tcp", vmess.Net)
+	require.Equal(t, "http", vmess.Type)
+	require.Equal(t, "/index", vmess.Path)
+	require.Equal(t, "www.example.com", vmess.Host)
+	require.Equal(t, 64, vmess.Aid.Value())
+
+	vless := items[2].(*VlessURL).Config
+	require.Equal(t, "reality", vless.Security)
+	require.Equal(t, "xtls-rprx-vision", vless.Flow)
+	require.Equal(t, "pubkey", vless.PublicKey)
+	require.Equal(t, "6ba85179e30d4fc2", vless.ShortID)
+	require.Equal(t, "chrome", vless.Fingerprint)
+	require.Equal(t, "www.microsoft.com", vless.SNI)
+
+	trojan := items[3].(*TrojanURL).Config
+	require.Equal(t, "grpc", trojan.Type)
+	require.Equal(t, "tunnel", trojan.ServiceName)
+	require.Equal(t, "h2", trojan.ALPN)
+	require.True(t, trojan.AllowInsecure)
+
+	ssr := items[4].(*SSRURL).Config
+	require.Equal(t, "auth_aes128_md5", ssr.Protocol)
+	require.Equal(t, "1:abc", ssr.ProtocolParam)
+	require.Equal(t, "download.windowsupdate.com", ssr.ObfsParam)
+
+	// every entry must come out as a link proxyclient.New understands
+	for _, it := range items {
+		u, err := url.Parse(it.Raw().String())
+		require.NoError(t, err)
+
+		parsed, err := proxyclient.ParseURL(u.String())
+		require.NoError(t, err)
+		requireSameConfig(t, it, parsed)
+	}
+}
+
+func TestMarshalClash(t *testing.T) {
+	items, _ := proxyclient.ParseClash(strings.NewReader(clashConfig))
+
+	buf, errs := proxyclient.MarshalClash(items)
+	require.Empty(t, errs)
+
+	again, errs := proxyclient.ParseClash(strings.NewReader(string(buf)))
+	require.Empty(t, errs)
+	require.Len(t, again, len(items))
+
+	for i := range items {
+		requireSameConfig(t, items[i], again[i])
+	}
+
+	t.Run("dropped fields", func(t *testing.T) {
+		u, err := proxyclient.ParseURL("trojan://secret@trojan.example.com:443?flow=xtls-rprx-direct#t")
+		require.NoError(t, err)
+
+		p, err := proxyclient.MarshalClashProxy(u)
+		require.ErrorIs(t, err, proxyclient.ErrUnsupportedClashField)
+		require.Equal(t, "trojan.example.com", p["server"])
+	})
+
+	t.Run("unsupported network", func(t *testing.T) {
+		u, err := proxyclient.ParseURL("vless://b831381d-6324-4d53-ad4f-8cda48b30811@vless.example.com:443?type=kcp")
+		require.NoError(t, err)
+
+		_, errs := proxyclient.MarshalClash([]proxyclient.URL{u})
+		require.Len(t, errs, 1)
+		require.NotErrorIs(t, errs[0], proxyclient.ErrUnsupportedClashField)
+	})
+}
+
+// requireSameConfig compares the configs behind two URLs, ignoring the raw
+// link they were parsed from.
+func requireSameConfig(t *testing.T, want, got proxyclient.URL) {
+	t.Helper()
+
+	switch w := want.(type) {
+	case *VmessURL:
+		a, b := *w.Config, *got.(*VmessURL).Config
+		a.raw, b.raw = nil, nil
+		require.Equal(t, a, b)
+	case *VlessURL:
+		a, b := *w.Config, *got.(*VlessURL).Config
+		a.raw, b.raw = nil, nil
+		require.Equal(t, a, b)
+	case *TrojanURL:
+		a, b := *w.Config, *got.(*TrojanURL).Config
+		a.raw, b.raw = nil, nil
+		require.Equal(t, a, b)
+	case *SSRURL:
+		a, b := *w.Config, *got.(*SSRURL).Config
+		a.raw, b.raw = nil, nil
+		require.Equal(t, a, b)
+	default:
+		t.Fatalf("unexpected url type %T", want)
+	}
+}
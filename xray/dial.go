@@ -5,23 +5,153 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/cnlangzi/proxyclient"
 	xnet "github.com/xtls/xray-core/common/net"
 	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/transport/internet"
 )
 
+// dialer opens connections through a running xray instance. It holds a
+// reference on the instance until it is closed, and every connection it
+// opens holds one until that is closed.
+type dialer struct {
+	proxyclient.UDPDialer
+	srv       *Server
+	closed    atomic.Bool
+	closeOnce sync.Once
+}
+
+// Close releases the dialer's reference on the xray instance. The instance
+// stops once the connections opened through it are closed as well, unless
+// other dialers still use it.
+func (d *dialer) Close() error {
+	d.closeOnce.Do(func() {
+		d.closed.Store(true)
+		d.srv.release()
+	})
+	return nil
+}
+
+// newDialer returns a dialer that opens connections through srv. When
+// forward is not nil the xray core reaches its server through forward.
+func newDialer(srv *Server, forward proxyclient.ContextDialer) *dialer {
+	d := &dialer{srv: srv}
+	d.UDPDialer = proxyclient.UDPDialer{
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if !d.acquire() {
+				return nil, fmt.Errorf("xray: %w", net.ErrClosed)
+			}
+
+			if forward != nil {
+				ctx = withForward(ctx, forward)
+			}
+			conn, err := dialContext(srv.route(ctx), srv.Instance, network, addr)
+			if err != nil {
+				srv.release()
+				return nil, err
+			}
+			return &serverConn{Conn: conn, srv: srv}, nil
+		},
+		ListenPacketFunc: func(ctx context.Context, network string) (net.PacketConn, error) {
+			if !d.acquire() {
+				return nil, fmt.Errorf("xray: %w", net.ErrClosed)
+			}
+
+			pc, err := listenPacket(srv.route(ctx), srv.Instance, forward)
+			if err != nil {
+				srv.release()
+				return nil, err
+			}
+			return &serverPacketConn{PacketConn: pc, srv: srv}, nil
+		},
+	}
+	return d
+}
+
+// acquire takes a reference for a new connection.
+func (d *dialer) acquire() bool {
+	return !d.closed.Load() && d.srv.use()
+}
+
+// serverConn releases its reference on the instance when it is closed.
+type serverConn struct {
+	net.Conn
+	srv  *Server
+	once sync.Once
+}
+
+func (c *serverConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.srv.release)
+	return err
+}
+
+// serverPacketConn releases its reference on the instance when it is
+// closed.
+type serverPacketConn struct {
+	net.PacketConn
+	srv  *Server
+	once sync.Once
+}
+
+func (c *serverPacketConn) Close() error {
+	err := c.PacketConn.Close()
+	c.once.Do(c.srv.release)
+	return err
+}
+
+type forwardKey struct{}
+
+var forwardOnce sync.Once
+
+// withForward attaches forward to ctx. xray-core keeps the dial context all
+// the way down to its system dialer, which is swapped (once, process wide)
+// for forwardSystemDialer so that it can pick the forward dialer back up.
+func withForward(ctx context.Context, forward proxyclient.ContextDialer) context.Context {
+	forwardOnce.Do(func() {
+		internet.UseAlternativeSystemDialer(&forwardSystemDialer{})
+	})
+	return context.WithValue(ctx, forwardKey{}, forward)
+}
+
+// forwardSystemDialer behaves exactly like xray's default system dialer
+// unless the context carries a forward dialer.
+type forwardSystemDialer struct {
+	internet.DefaultSystemDialer
+}
+
+func (d *forwardSystemDialer) Dial(ctx context.Context, src xnet.Address, dest xnet.Destination, sockopt *internet.SocketConfig) (net.Conn, error) {
+	forward, ok := ctx.Value(forwardKey{}).(proxyclient.ContextDialer)
+	if !ok {
+		return d.DefaultSystemDialer.Dial(ctx, src, dest, sockopt)
+	}
+
+	// UDP based transports (kcp, quic) would silently bypass the chain.
+	if dest.Network != xnet.Network_TCP {
+		return nil, fmt.Errorf("xray: %s transport: %w", dest.Network, proxyclient.ErrChainUnsupported)
+	}
+
+	return forward.DialContext(ctx, "tcp", dest.NetAddr())
+}
+
+// dialContext dials addr through instance. Bad addresses count as
+// ErrConfigUnsupported and failures of the core to set up the connection as
+// ErrProxyUnreachable, unless they are classified already, as a failing
+// forward dialer's are.
 func dialContext(ctx context.Context, instance *core.Instance, network, addr string) (net.Conn, error) {
 
 	host, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid address: %w", err)
+		return nil, fmt.Errorf("%w: invalid address: %w", proxyclient.ErrConfigUnsupported, err)
 	}
 
 	// Convert port string to uint16
 	portNum, err := strconv.Atoi(portStr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid port: %w", err)
+		return nil, fmt.Errorf("%w: invalid port: %w", proxyclient.ErrConfigUnsupported, err)
 	}
 
 	// Create network type based on the network parameter
@@ -32,7 +162,7 @@ func dialContext(ctx context.Context, instance *core.Instance, network, addr str
 	case "udp", "udp4", "udp6":
 		netType = xnet.Network_UDP
 	default:
-		return nil, fmt.Errorf("unsupported network: %s", network)
+		return nil, fmt.Errorf("%w: unsupported network: %s", proxyclient.ErrConfigUnsupported, network)
 	}
 
 	// Create the destination
@@ -42,8 +172,17 @@ func dialContext(ctx context.Context, instance *core.Instance, network, addr str
 		Port:    xnet.Port(portNum),
 	}
 
-	return proxyclient.WithRecover(func() (net.Conn, error) {
+	conn, err := proxyclient.WithRecover(func() (net.Conn, error) {
 		return core.Dial(ctx, instance, dest)
 	})
-
+	if err != nil {
+		if proxyclient.Classify(err) != nil {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: xray: %w", proxyclient.ErrProxyUnreachable, err)
+	}
+	return conn, nil
 }
@@ -1,9 +1,7 @@
 package xray
 
 import (
-	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"net/url"
 
@@ -12,6 +10,8 @@ import (
 
 func init() {
 	proxyclient.RegisterProxy("ssr", DialSSR)
+	proxyclient.RegisterDialer("ssr", SSRDialer)
+	proxyclient.RegisterCapabilities("ssr", proxyclient.SchemeCapabilities{Package: "xray", Network: "tcp", TCP: true, UDP: true, RemoteDNS: true, Auth: true})
 }
 
 // ProxySSR creates a RoundTripper for SSR proxy
@@ -27,25 +27,28 @@ func init() {
 // 	return proxyclient.ProxySocks5(proxyURL, o)
 // }
 
-// DialSSR creates a custom transport that dials directly to the v2ray server
-// instead of using a local SOCKS proxy.
-func DialSSR(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
-	instance, _, err := StartSSR(u, 0)
+// SSRDialer creates a dialer that opens connections directly through the
+// xray core instead of a local SOCKS proxy.
+func SSRDialer(u *url.URL, o *proxyclient.Options) (proxyclient.ContextDialer, error) {
+	srv, err := acquireServer(u.String(), startConfig(func() ([]byte, int, error) {
+		return SSRToXRay(u, 0)
+	}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to start ssr proxy: %w", err)
 	}
 
-	// Create a transport that uses our custom dialer
-	tr := proxyclient.CreateTransport(o)
-	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		conn, err := dialContext(ctx, instance, network, addr)
-		if err != nil {
-			return nil, err
-		}
+	return newDialer(srv, o.Forward), nil
+}
 
-		return proxyclient.SetDeadline(conn, o.Timeout, tr.DisableKeepAlives)
+// DialSSR creates a custom transport that dials directly to the v2ray server
+// instead of using a local SOCKS proxy.
+func DialSSR(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
+	d, err := SSRDialer(u, o)
+	if err != nil {
+		return nil, err
 	}
-	tr.Proxy = nil
 
-	return tr, nil
+	tr := proxyclient.CreateDialerTransport(d, o)
+
+	return proxyclient.ClosableTransport(tr, d), nil
 }
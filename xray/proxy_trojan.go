@@ -1,9 +1,7 @@
 package xray
 
 import (
-	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"net/url"
 
@@ -12,6 +10,8 @@ import (
 
 func init() {
 	proxyclient.RegisterProxy("trojan", DialTrojan)
+	proxyclient.RegisterDialer("trojan", TrojanDialer)
+	proxyclient.RegisterCapabilities("trojan", proxyclient.SchemeCapabilities{Package: "xray", Network: "tcp", TCP: true, UDP: true, RemoteDNS: true, Auth: true})
 }
 
 // ProxyTrojan creates a RoundTripper for Trojan proxy
@@ -27,25 +27,28 @@ func init() {
 // 	return proxyclient.ProxySocks5(proxyURL, o)
 // }
 
-// DialTrojan creates a custom transport that dials directly to the v2ray server
-// instead of using a local SOCKS proxy.
-func DialTrojan(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
-	instance, _, err := StartTrojan(u, 0)
+// TrojanDialer creates a dialer that opens connections directly through the
+// xray core instead of a local SOCKS proxy.
+func TrojanDialer(u *url.URL, o *proxyclient.Options) (proxyclient.ContextDialer, error) {
+	srv, err := acquireServer(u.String(), startConfig(func() ([]byte, int, error) {
+		return TrojanToXRay(u, 0)
+	}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to start trojan proxy: %w", err)
 	}
 
-	// Create a transport that uses our custom dialer
-	tr := proxyclient.CreateTransport(o)
-	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		conn, err := dialContext(ctx, instance, network, addr)
-		if err != nil {
-			return nil, err
-		}
+	return newDialer(srv, o.Forward), nil
+}
 
-		return proxyclient.SetDeadline(conn, o.Timeout, tr.DisableKeepAlives)
+// DialTrojan creates a custom transport that dials directly to the v2ray server
+// instead of using a local SOCKS proxy.
+func DialTrojan(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
+	d, err := TrojanDialer(u, o)
+	if err != nil {
+		return nil, err
 	}
-	tr.Proxy = nil
 
-	return tr, nil
+	tr := proxyclient.CreateDialerTransport(d, o)
+
+	return proxyclient.ClosableTransport(tr, d), nil
 }
@@ -1,9 +1,7 @@
 package xray
 
 import (
-	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"net/url"
 
@@ -12,6 +10,8 @@ import (
 
 func init() {
 	proxyclient.RegisterProxy("vmess", DialVmess)
+	proxyclient.RegisterDialer("vmess", VmessDialer)
+	proxyclient.RegisterCapabilities("vmess", proxyclient.SchemeCapabilities{Package: "xray", Network: "tcp", TCP: true, UDP: true, RemoteDNS: true, Auth: true})
 }
 
 // func ProxyVmess(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
@@ -24,25 +24,28 @@ func init() {
 // 	return proxyclient.ProxySocks5(proxyURL, o)
 // }
 
-// DialVmess creates a custom transport that dials directly to the v2ray server
-// instead of using a local SOCKS proxy.
-func DialVmess(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
-	instance, _, err := StartVmess(u, 0)
+// VmessDialer creates a dialer that opens connections directly through the
+// xray core instead of a local SOCKS proxy.
+func VmessDialer(u *url.URL, o *proxyclient.Options) (proxyclient.ContextDialer, error) {
+	srv, err := acquireServer(u.String(), startConfig(func() ([]byte, int, error) {
+		return vmessConfig(u, 0)
+	}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to start vmess proxy: %w", err)
 	}
 
-	// Create a transport that uses our custom dialer
-	tr := proxyclient.CreateTransport(o)
-	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		conn, err := dialContext(ctx, instance, network, addr)
-		if err != nil {
-			return nil, err
-		}
+	return newDialer(srv, o.Forward), nil
+}
 
-		return proxyclient.SetDeadline(conn, o.Timeout, tr.DisableKeepAlives)
+// DialVmess creates a custom transport that dials directly to the v2ray server
+// instead of using a local SOCKS proxy.
+func DialVmess(u *url.URL, o *proxyclient.Options) (http.RoundTripper, error) {
+	d, err := VmessDialer(u, o)
+	if err != nil {
+		return nil, err
 	}
-	tr.Proxy = nil
 
-	return tr, nil
+	tr := proxyclient.CreateDialerTransport(d, o)
+
+	return proxyclient.ClosableTransport(tr, d), nil
 }
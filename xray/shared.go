@@ -0,0 +1,159 @@
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/xtls/xray-core/common/session"
+	core "github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/outbound"
+)
+
+// sharedMode makes new proxies outbounds of the shared core; see SetShared.
+var sharedMode atomic.Bool
+
+// shared is the core hosting the outbounds of shared mode. It runs while it
+// has at least one outbound.
+var shared struct {
+	mu        sync.Mutex
+	instance  *core.Instance
+	outbounds int
+	next      uint64
+}
+
+// SetShared switches shared mode on or off. In shared mode a proxy URL does
+// not start a core of its own, with its own dispatcher, DNS and router, but
+// becomes a tagged outbound of one core shared by every proxy of the
+// process, added to and removed from it at runtime. Connections of the proxy
+// are routed to its outbound by tag. This saves most of the per-proxy cost
+// when running many proxies at once.
+//
+// The mode applies to the instances started after the call; running ones
+// keep theirs. In shared mode StartVmess and the like neither open nor
+// allocate a local SOCKS port, and closing the Instance they return removes
+// only the proxy's outbound.
+func SetShared(enabled bool) {
+	sharedMode.Store(enabled)
+}
+
+// addOutbound adds the first outbound of config to the shared core,
+// starting the core if it is not running.
+func addOutbound(config *core.Config, port int) (*Server, error) {
+	if len(config.Outbound) == 0 {
+		return nil, fmt.Errorf("xray: config has no outbound: %w", proxyclient.ErrConfigUnsupported)
+	}
+
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	if shared.instance == nil {
+		instance, err := startShared()
+		if err != nil {
+			return nil, err
+		}
+		shared.instance = instance
+	}
+
+	shared.next++
+	ob := config.Outbound[0]
+	ob.Tag = "proxy-" + strconv.FormatUint(shared.next, 10)
+
+	if err := core.AddOutboundHandler(shared.instance, ob); err != nil {
+		if shared.outbounds == 0 {
+			shared.instance.Close() //nolint: errcheck
+			shared.instance = nil
+		}
+		return nil, fmt.Errorf("failed to add Xray outbound: %w", err)
+	}
+	shared.outbounds++
+
+	return &Server{Instance: shared.instance, SocksPort: port, tag: ob.Tag}, nil
+}
+
+// removeOutbound removes and closes the outbound of s, and stops the shared
+// core once it has no outbound left.
+func removeOutbound(s *Server) {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	om, ok := s.Instance.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if ok {
+		h := om.GetHandler(s.tag)
+		om.RemoveHandler(context.Background(), s.tag) //nolint: errcheck
+		if h != nil {
+			h.Close() //nolint: errcheck
+		}
+	}
+
+	if s.Instance != shared.instance {
+		return
+	}
+	shared.outbounds--
+	if shared.outbounds == 0 {
+		shared.instance.Close() //nolint: errcheck
+		shared.instance = nil
+	}
+}
+
+// startShared starts the shared core. Its only outbound of its own is the
+// default one, a blackhole, so that nothing leaves it without a tag.
+func startShared() (*core.Instance, error) {
+	jsonConfig, err := json.Marshal(&XRayConfig{
+		Log: &LogConfig{
+			Access:   "none",
+			Loglevel: "error",
+		},
+		Outbounds: []Outbound{
+			{
+				Tag:      "blocked",
+				Protocol: "blackhole",
+				Settings: map[string]interface{}{},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := core.StartInstance("json", jsonConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Xray instance: %w", err)
+	}
+	return instance, nil
+}
+
+// route makes the core send what is dialed with ctx through the outbound
+// of s when s is an outbound of the shared core.
+func (s *Server) route(ctx context.Context) context.Context {
+	if s.tag == "" {
+		return ctx
+	}
+	return session.SetForcedOutboundTagToContext(ctx, s.tag)
+}
+
+// sharedOutbounds returns the number of outbounds on the shared core and
+// whether it is running.
+func sharedOutbounds() (int, bool) {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	return shared.outbounds, shared.instance != nil
+}
+
+// resetShared stops the shared core and leaves shared mode, for
+// ResetForTest.
+func resetShared() {
+	sharedMode.Store(false)
+
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	if shared.instance != nil {
+		shared.instance.Close() //nolint: errcheck
+	}
+	shared.instance = nil
+	shared.outbounds = 0
+}
@@ -7,7 +7,6 @@ import (
 	"strings"
 
 	"github.com/cnlangzi/proxyclient"
-	core "github.com/xtls/xray-core/core"
 )
 
 // convertSSRMethod converts SSR encryption method to Xray supported method
@@ -82,8 +81,8 @@ func SSRToXRay(u *url.URL, port int) ([]byte, int, error) {
 			cfg.Protocol, cfg.Obfs, cfg.Method)
 	}
 
-	// Get a free port (if not provided)
-	if port < 1 {
+	// Get a free port (if not provided); the shared core opens no inbound
+	if port < 1 && !sharedMode.Load() {
 		port, err = proxyclient.GetFreePort()
 		if err != nil {
 			return nil, 0, err
@@ -188,30 +187,15 @@ func SSRToXRay(u *url.URL, port int) ([]byte, int, error) {
 	return buf, port, nil
 }
 
-// StartSSR starts SSR client and returns Xray instance and local SOCKS port
-func StartSSR(u *url.URL, port int) (*core.Instance, int, error) {
-	ssrURL := u.String()
-	// Check if already running
-	server := getServer(ssrURL)
-	if server != nil {
-		return server.Instance, server.SocksPort, nil
-	}
-
-	// Convert to Xray JSON configuration
-	jsonConfig, port, err := SSRToXRay(u, port)
+// StartSSR starts SSR client and returns Xray instance and local SOCKS port.
+// See Server for how long the instance runs.
+func StartSSR(u *url.URL, port int) (*Instance, int, error) {
+	srv, err := pinServer(u.String(), startConfig(func() ([]byte, int, error) {
+		return SSRToXRay(u, port)
+	}))
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Start Xray instance
-	instance, err := core.StartInstance("json", jsonConfig)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to start Xray instance: %w", err)
-	}
-
-	// Register the running server
-	setServer(ssrURL, instance, port)
-
-	// fmt.Printf("SSR proxy started on socks5://127.0.0.1:%d\n", port)
-	return instance, port, nil
+	return &Instance{Instance: srv.Instance, srv: srv}, srv.SocksPort, nil
 }
@@ -185,3 +185,43 @@ func ParseSSRURL(u *url.URL) (*SSRURL, error) {
 		Config: cfg,
 	}, nil
 }
+
+// Encode returns the canonical ssr:// link of Config. ParseSSRURL reads it
+// back into an identical Config.
+func (v *SSRURL) Encode() string {
+	if v.Config == nil {
+		return ""
+	}
+	c := v.Config
+
+	enc := base64.RawURLEncoding
+
+	text := strings.Join([]string{
+		c.Server,
+		strconv.Itoa(c.Port),
+		c.Protocol,
+		c.Method,
+		c.Obfs,
+		enc.EncodeToString([]byte(c.Password)),
+	}, ":")
+
+	var params []string
+	if c.ObfsParam != "" {
+		params = append(params, "obfsparam="+enc.EncodeToString([]byte(c.ObfsParam)))
+	}
+	if c.ProtocolParam != "" {
+		params = append(params, "protoparam="+enc.EncodeToString([]byte(c.ProtocolParam)))
+	}
+	if c.Name != "" {
+		params = append(params, "remarks="+enc.EncodeToString([]byte(c.Name)))
+	}
+	if len(params) > 0 {
+		text += "/?" + strings.Join(params, "&")
+	}
+
+	return "ssr://" + enc.EncodeToString([]byte(text))
+}
+
+func (v *SSRURL) String() string {
+	return v.Encode()
+}
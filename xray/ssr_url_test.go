@@ -0,0 +1,30 @@
+package xray
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSRURLEncode(t *testing.T) {
+	u := &SSRURL{Config: &SSRConfig{
+		Server:        "ssr.example.com",
+		Port:          8388,
+		Method:        "aes-256-cfb",
+		Password:      "p@ss:word",
+		Protocol:      "auth_aes128_md5",
+		ProtocolParam: "1:abc",
+		Obfs:          "tls1.2_ticket_auth",
+		ObfsParam:     "download.windowsupdate.com",
+		Name:          "香港 01",
+	}}
+
+	again := requireEncodeRoundTrip(t, u, func(u *url.URL) (proxyclient.URL, error) { return ParseSSRURL(u) })
+	require.Equal(t, "香港 01", again.Name())
+
+	// the minimal form without parameters
+	u.Config.ProtocolParam, u.Config.ObfsParam, u.Config.Name = "", "", ""
+	requireEncodeRoundTrip(t, u, func(u *url.URL) (proxyclient.URL, error) { return ParseSSRURL(u) })
+}
@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/cnlangzi/proxyclient"
-	core "github.com/xtls/xray-core/core"
 	_ "github.com/xtls/xray-core/main/distro/all"
 )
 
@@ -22,8 +21,8 @@ func TrojanToXRay(u *url.URL, port int) ([]byte, int, error) {
 
 	cfg := tu.Config
 
-	// Get a free port if none provided
-	if port < 1 {
+	// Get a free port if none provided; the shared core opens no inbound
+	if port < 1 && !sharedMode.Load() {
 		port, err = proxyclient.GetFreePort()
 		if err != nil {
 			return nil, 0, err
@@ -198,31 +197,15 @@ func TrojanToXRay(u *url.URL, port int) ([]byte, int, error) {
 	return buf, port, nil
 }
 
-// StartTrojan starts a Trojan client and returns Xray instance and local SOCKS port
-func StartTrojan(u *url.URL, port int) (*core.Instance, int, error) {
-
-	trojanURL := u.String()
-
-	// Check if already running
-	server := getServer(trojanURL)
-	if server != nil {
-		return server.Instance, server.SocksPort, nil
-	}
-
-	// Convert to Xray JSON configuration
-	jsonConfig, port, err := TrojanToXRay(u, port)
+// StartTrojan starts a Trojan client and returns Xray instance and local SOCKS port.
+// See Server for how long the instance runs.
+func StartTrojan(u *url.URL, port int) (*Instance, int, error) {
+	srv, err := pinServer(u.String(), startConfig(func() ([]byte, int, error) {
+		return TrojanToXRay(u, port)
+	}))
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Start Xray instance
-	instance, err := core.StartInstance("json", jsonConfig)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to start Xray instance: %w", err)
-	}
-
-	// Register the running server
-	setServer(trojanURL, instance, port)
-
-	return instance, port, nil
+	return &Instance{Instance: srv.Instance, srv: srv}, srv.SocksPort, nil
 }
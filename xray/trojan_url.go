@@ -179,3 +179,39 @@ func ParseTrojanURL(u *url.URL) (*TrojanURL, error) {
 		Config: config,
 	}, nil
 }
+
+// Encode returns the canonical trojan:// link of Config. ParseTrojanURL
+// reads it back into an identical Config.
+func (v *TrojanURL) Encode() string {
+	if v.Config == nil {
+		return ""
+	}
+	c := v.Config
+
+	q := url.Values{}
+	setQuery(q, "flow", c.Flow)
+	setQuery(q, "type", c.Type)
+	setQuery(q, "security", c.Security)
+	setQuery(q, "path", c.Path)
+	setQuery(q, "host", c.Host)
+	setQuery(q, "sni", c.SNI)
+	setQuery(q, "alpn", c.ALPN)
+	setQuery(q, "fp", c.Fingerprint)
+	setQuery(q, "serviceName", c.ServiceName)
+	if !c.AllowInsecure {
+		q.Set("allowInsecure", "0")
+	}
+
+	u := &url.URL{
+		Scheme:   "trojan",
+		User:     url.User(c.Password),
+		Host:     net.JoinHostPort(c.Address, strconv.Itoa(c.Port)),
+		RawQuery: q.Encode(),
+		Fragment: c.Remark,
+	}
+	return u.String()
+}
+
+func (v *TrojanURL) String() string {
+	return v.Encode()
+}
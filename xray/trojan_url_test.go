@@ -0,0 +1,32 @@
+package xray
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrojanURLEncode(t *testing.T) {
+	links := []string{
+		"trojan://secret@trojan.example.com:443#trojan",
+		"trojan://p%40ss%3Aword@1.2.3.4:443?security=tls&type=ws&path=%2Fws&host=cdn.example.com&allowInsecure=0",
+		"trojan://secret@trojan.example.com:443?type=grpc&serviceName=tunnel&sni=sni.example.com&fp=chrome&alpn=h2",
+	}
+
+	for _, link := range links {
+		u, err := url.Parse(link)
+		require.NoError(t, err)
+
+		trojanURL, err := ParseTrojanURL(u)
+		require.NoError(t, err)
+
+		trojanURL.Config.Remark = "renamed"
+		trojanURL.Config.SNI = "override.example.com"
+
+		again := requireEncodeRoundTrip(t, trojanURL, func(u *url.URL) (proxyclient.URL, error) { return ParseTrojanURL(u) })
+		require.Equal(t, "renamed", again.Name())
+		require.Equal(t, "override.example.com", again.(*TrojanURL).Config.SNI)
+	}
+}
@@ -0,0 +1,108 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol/udp"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/routing"
+	xudp "github.com/xtls/xray-core/transport/internet/udp"
+)
+
+// listenPacket relays UDP through the outbound of instance. Unlike
+// core.DialUDP it keeps domain names for the server to resolve.
+func listenPacket(ctx context.Context, instance *core.Instance, forward proxyclient.ContextDialer) (net.PacketConn, error) {
+	dispatcher, ok := instance.GetFeature(routing.DispatcherType()).(routing.Dispatcher)
+	if !ok {
+		return nil, fmt.Errorf("xray: routing.Dispatcher is not registered")
+	}
+
+	// the session outlives the context ListenPacket was called with
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	if forward != nil {
+		ctx = withForward(ctx, forward)
+	}
+
+	c := &udpConn{
+		ctx:     ctx,
+		cancel:  cancel,
+		packets: make(chan *udp.Packet, 16),
+		done:    make(chan struct{}),
+	}
+	c.dispatcher = xudp.NewDispatcher(dispatcher, c.callback)
+
+	return proxyclient.NewPacketConn(c), nil
+}
+
+// udpConn is a proxyclient.DatagramConn on top of xray's UDP dispatcher.
+type udpConn struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	dispatcher *xudp.Dispatcher
+	packets    chan *udp.Packet
+	done       chan struct{}
+	once       sync.Once
+}
+
+func (c *udpConn) callback(ctx context.Context, packet *udp.Packet) {
+	select {
+	case c.packets <- packet:
+	case <-c.done:
+		packet.Payload.Release()
+	default:
+		// drop it, as a full socket buffer would
+		packet.Payload.Release()
+	}
+}
+
+func (c *udpConn) Receive() ([]byte, string, error) {
+	select {
+	case packet := <-c.packets:
+		b := append([]byte(nil), packet.Payload.Bytes()...)
+		packet.Payload.Release()
+		return b, packet.Source.NetAddr(), nil
+	case <-c.done:
+		return nil, "", net.ErrClosed
+	}
+}
+
+func (c *udpConn) Send(b []byte, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%w: invalid address: %w", proxyclient.ErrConfigUnsupported, err)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("%w: invalid port: %w", proxyclient.ErrConfigUnsupported, err)
+	}
+
+	if len(b) > buf.Size {
+		return fmt.Errorf("xray: datagram of %d bytes exceeds %d", len(b), buf.Size)
+	}
+
+	dest := xnet.UDPDestination(xnet.ParseAddress(host), xnet.Port(port))
+
+	payload := buf.New()
+	payload.Write(b) //nolint: errcheck
+	payload.UDP = &dest
+
+	c.dispatcher.Dispatch(c.ctx, dest, payload)
+	return nil
+}
+
+func (c *udpConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.dispatcher.RemoveRay()
+		c.cancel()
+	})
+	return nil
+}
@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/cnlangzi/proxyclient"
-	core "github.com/xtls/xray-core/core"
 	_ "github.com/xtls/xray-core/main/distro/all"
 )
 
@@ -20,8 +19,8 @@ func init() {
 func VlessToXRay(vu *VlessURL, port int) ([]byte, int, error) {
 
 	var err error
-	// Get a free port (if not provided)
-	if port < 1 {
+	// Get a free port (if not provided); the shared core opens no inbound
+	if port < 1 && !sharedMode.Load() {
 		port, err = proxyclient.GetFreePort()
 		if err != nil {
 			return nil, 0, err
@@ -209,37 +208,25 @@ func VlessToXRay(vu *VlessURL, port int) ([]byte, int, error) {
 	return buf, port, nil
 }
 
-// StartVless starts a VLESS client and returns Xray instance and local SOCKS port
-func StartVless(u *url.URL, port int) (*core.Instance, int, error) {
-
-	vlessURL := u.String()
-
-	// Check if already running
-	server := getServer(vlessURL)
-	if server != nil {
-		return server.Instance, server.SocksPort, nil
-	}
-
-	vu, err := ParseVlessURL(u)
+// StartVless starts a VLESS client and returns Xray instance and local SOCKS port.
+// See Server for how long the instance runs.
+func StartVless(u *url.URL, port int) (*Instance, int, error) {
+	srv, err := pinServer(u.String(), startConfig(func() ([]byte, int, error) {
+		return vlessConfig(u, port)
+	}))
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Convert to Xray JSON configuration
-	jsonConfig, port, err := VlessToXRay(vu, port)
-	if err != nil {
-		return nil, 0, err
-	}
+	return &Instance{Instance: srv.Instance, srv: srv}, srv.SocksPort, nil
+}
 
-	// Start Xray instance
-	instance, err := core.StartInstance("json", jsonConfig)
+// vlessConfig builds the xray configuration of u.
+func vlessConfig(u *url.URL, port int) ([]byte, int, error) {
+	vu, err := ParseVlessURL(u)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to start Xray instance: %w", err)
+		return nil, 0, err
 	}
 
-	// Register the running server
-	setServer(vlessURL, instance, port)
-
-	// fmt.Printf("VLESS proxy started on socks5://127.0.0.1:%d\n", port)
-	return instance, port, nil
+	return VlessToXRay(vu, port)
 }
@@ -195,3 +195,50 @@ func ParseVlessURL(u *url.URL) (*VlessURL, error) {
 		Config: cfg,
 	}, nil
 }
+
+// Encode returns the canonical vless:// link of Config. ParseVlessURL reads
+// it back into an identical Config.
+func (v *VlessURL) Encode() string {
+	if v.Config == nil {
+		return ""
+	}
+	c := v.Config
+
+	q := url.Values{}
+	setQuery(q, "encryption", c.Encryption)
+	setQuery(q, "flow", c.Flow)
+	setQuery(q, "type", c.Type)
+	setQuery(q, "security", c.Security)
+	setQuery(q, "path", c.Path)
+	setQuery(q, "host", c.Host)
+	setQuery(q, "sni", c.SNI)
+	setQuery(q, "alpn", c.ALPN)
+	setQuery(q, "fp", c.Fingerprint)
+	setQuery(q, "pbk", c.PublicKey)
+	setQuery(q, "sid", c.ShortID)
+	setQuery(q, "spx", c.SpiderX)
+	setQuery(q, "serviceName", c.ServiceName)
+	if !c.AllowInsecure {
+		q.Set("allowInsecure", "0")
+	}
+
+	u := &url.URL{
+		Scheme:   "vless",
+		User:     url.User(c.UUID),
+		Host:     net.JoinHostPort(c.Address, strconv.Itoa(c.Port)),
+		RawQuery: q.Encode(),
+		Fragment: c.Remark,
+	}
+	return u.String()
+}
+
+func (v *VlessURL) String() string {
+	return v.Encode()
+}
+
+// setQuery sets key only when v is not empty, keeping links short.
+func setQuery(q url.Values, key, v string) {
+	if v != "" {
+		q.Set(key, v)
+	}
+}
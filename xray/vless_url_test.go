@@ -0,0 +1,32 @@
+package xray
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVlessURLEncode(t *testing.T) {
+	links := []string{
+		"vless://b831381d-6324-4d53-ad4f-8cda48b30811@1.2.3.4:443?encryption=none&flow=xtls-rprx-vision&security=reality&sni=www.microsoft.com&fp=chrome&pbk=pubkey&sid=6ba85179e30d4fc2&spx=%2F&type=tcp#reality%20node",
+		"vless://b831381d-6324-4d53-ad4f-8cda48b30811@cdn.example.com:443?type=ws&security=tls&path=%2Fws%3Fed%3D2048&host=cdn.example.com&allowInsecure=0",
+		"vless://b831381d-6324-4d53-ad4f-8cda48b30811@[2001:db8::1]:8443?type=grpc&serviceName=tunnel&security=tls&alpn=h2,http/1.1",
+	}
+
+	for _, link := range links {
+		u, err := url.Parse(link)
+		require.NoError(t, err)
+
+		vlessURL, err := ParseVlessURL(u)
+		require.NoError(t, err)
+
+		vlessURL.Config.Remark = "renamed #1"
+		vlessURL.Config.SNI = "sni.example.com"
+
+		again := requireEncodeRoundTrip(t, vlessURL, func(u *url.URL) (proxyclient.URL, error) { return ParseVlessURL(u) })
+		require.Equal(t, "renamed #1", again.Name())
+		require.Equal(t, "sni.example.com", again.(*VlessURL).Config.SNI)
+	}
+}
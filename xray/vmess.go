@@ -3,20 +3,18 @@ package xray
 import (
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
 	"net/url"
 	"runtime"
 	"strings"
 
 	"github.com/cnlangzi/proxyclient"
-	core "github.com/xtls/xray-core/core"
 	_ "github.com/xtls/xray-core/main/distro/all"
 )
 
 // VmessToXRay converts VMess URL to Xray JSON configuration
 func VmessToXRay(vmess *VmessConfig, port int) ([]byte, int, error) {
 	var err error
-	if port < 1 {
+	if port < 1 && !sharedMode.Load() {
 		port, err = proxyclient.GetFreePort()
 		if err != nil {
 			return nil, 0, err
@@ -305,35 +303,25 @@ func configureGRPC(ss *StreamSettings, vmess *VmessConfig) {
 	}
 }
 
-// StartVmess starts a VMess client
-func StartVmess(u *url.URL, port int) (*core.Instance, int, error) {
-
-	vmessURL := u.String()
-
-	// Check if already running
-	server := getServer(vmessURL)
-	if server != nil {
-		return server.Instance, server.SocksPort, nil
-	}
-
-	vu, err := ParseVmessURL(u)
+// StartVmess starts a VMess client.
+// See Server for how long the instance runs.
+func StartVmess(u *url.URL, port int) (*Instance, int, error) {
+	srv, err := pinServer(u.String(), startConfig(func() ([]byte, int, error) {
+		return vmessConfig(u, port)
+	}))
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Get JSON configuration
-	jsonConfig, port, err := VmessToXRay(vu.Config, port)
-	if err != nil {
-		return nil, 0, err
-	}
+	return &Instance{Instance: srv.Instance, srv: srv}, srv.SocksPort, nil
+}
 
-	// Directly use Xray's StartInstance function to create server configuration
-	instance, err := core.StartInstance("json", jsonConfig)
+// vmessConfig builds the xray configuration of u.
+func vmessConfig(u *url.URL, port int) ([]byte, int, error) {
+	vu, err := ParseVmessURL(u)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to start Xray instance: %w", err)
+		return nil, 0, err
 	}
 
-	setServer(vmessURL, instance, port)
-
-	return instance, port, nil
+	return VmessToXRay(vu.Config, port)
 }
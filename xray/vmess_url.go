@@ -1,7 +1,9 @@
 package xray
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
@@ -126,3 +128,30 @@ func ParseVmessURL(u *url.URL) (*VmessURL, error) {
 		Config: vmess,
 	}, nil
 }
+
+// Encode returns the canonical vmess:// link of Config, the config as base64
+// encoded JSON. ParseVmessURL reads it back into an identical Config. It
+// returns "" when there is no Config or it cannot be encoded; EncodeLink
+// tells why.
+func (v *VmessURL) Encode() string {
+	link, _ := v.EncodeLink()
+	return link
+}
+
+// EncodeLink is Encode, with the error that kept Config from being encoded.
+func (v *VmessURL) EncodeLink() (string, error) {
+	if v.Config == nil {
+		return "", errors.New("xray: vmess URL has no config")
+	}
+
+	buf, err := json.Marshal(v.Config)
+	if err != nil {
+		return "", fmt.Errorf("xray: encoding vmess config: %w", err)
+	}
+
+	return "vmess://" + base64.StdEncoding.EncodeToString(buf), nil
+}
+
+func (v *VmessURL) String() string {
+	return v.Encode()
+}
@@ -1,9 +1,11 @@
 package xray
 
 import (
+	"fmt"
 	"net/url"
 	"testing"
 
+	"github.com/cnlangzi/proxyclient"
 	"github.com/stretchr/testify/require"
 )
 
@@ -19,3 +21,51 @@ func TestVmessURL(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "vmess", vmessURL.Protocol())
 }
+
+func TestVmessURLEncode(t *testing.T) {
+	links := []string{
+		"vmess://ewogICJ2IjogMiwKICAicHMiOiAiVk1FU1Mt5pyq55+lPuW+t+WbvS1ORuino+mUgeW+t+WbveWcsOWMuumdnuiHquWItuWJpy1DaGF0R1BULVRpa1Rvay1Zb3VUdWJlLWRlMDEuc2gtY2xvdWRmbGFyZS5zYnM6MjA5NiIsCiAgImFkZCI6ICJkZTAxLnNoLWNsb3VkZmxhcmUuc2JzIiwKICAicG9ydCI6IDIwOTYsCiAgImlkIjogIjc1YTA4ODVmLTBjYTUtNDJhNC04NjUxLTM5MWNmODE5MzE1NCIsCiAgImFpZCI6IDAsCiAgInNjeSI6ICJhdXRvIiwKICAibmV0IjogIndzIiwKICAidHlwZSI6IG51bGwsCiAgImhvc3QiOiAiZGUwMS5zaC1jbG91ZGZsYXJlLnNicyIsCiAgInBhdGgiOiAiLyIsCiAgInRscyI6IHRydWUsCiAgInNuaSI6ICIiCn0=",
+		"vmess://eyJ2IjogIjIiLCAicHMiOiAiXHU1YzcxXHU0ZTFjXHU3NzAxXHU5NzUyXHU1YzliXHU1ZTAyIFx1ODA1NFx1OTAxYSIsICJhZGQiOiAidjQwLmhlZHVpYW4ubGluayIsICJwb3J0IjogIjMwODQwIiwgInR5cGUiOiAibm9uZSIsICJpZCI6ICJjYmIzZjg3Ny1kMWZiLTM0NGMtODdhOS1kMTUzYmZmZDU0ODQiLCAiYWlkIjogIjAiLCAibmV0IjogIndzIiwgInBhdGgiOiAiL2luZGV4IiwgImhvc3QiOiAiYXBpMTAwLWNvcmUtcXVpYy1sZi5hbWVtdi5jb20iLCAidGxzIjogIiJ9",
+	}
+
+	for _, link := range links {
+		u, err := url.Parse(link)
+		require.NoError(t, err)
+
+		vmessURL, err := ParseVmessURL(u)
+		require.NoError(t, err)
+
+		vmessURL.Config.PS = "renamed"
+		vmessURL.Config.SNI = "sni.example.com"
+
+		again := requireEncodeRoundTrip(t, vmessURL, func(u *url.URL) (proxyclient.URL, error) { return ParseVmessURL(u) })
+		require.Equal(t, "renamed", again.Name())
+		require.Equal(t, "sni.example.com", again.(*VmessURL).Config.SNI)
+	}
+
+	empty := &VmessURL{}
+	require.Empty(t, empty.Encode())
+	_, err := empty.EncodeLink()
+	require.Error(t, err)
+}
+
+// requireEncodeRoundTrip encodes u, parses the link back with parse and
+// requires the same config and the same canonical link. It returns the
+// parsed URL.
+func requireEncodeRoundTrip(t *testing.T, u proxyclient.URL, parse func(*url.URL) (proxyclient.URL, error)) proxyclient.URL {
+	t.Helper()
+
+	link := u.Encode()
+	require.Equal(t, link, fmt.Sprint(u))
+
+	raw, err := url.Parse(link)
+	require.NoError(t, err)
+
+	again, err := parse(raw)
+	require.NoError(t, err)
+
+	requireSameConfig(t, u, again)
+	require.Equal(t, link, again.Encode())
+
+	return again
+}
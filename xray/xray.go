@@ -1,9 +1,15 @@
 package xray
 
 import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cnlangzi/proxyclient"
 	core "github.com/xtls/xray-core/core"
 	// The following are necessary as they register handlers in their init functions.
 	// Mandatory features. Can't remove unless there are replacements.
@@ -60,32 +66,88 @@ import (
 	_ "github.com/xtls/xray-core/main/json"
 )
 
-// DrainTimeout is how long an instance stays in the servers map after Close()
-// is called. This gives in-flight operations a chance to finish before the
-// instance is actually closed, preventing goroutine leaks in xray-core.
-var DrainTimeout = 30 * time.Second
-
-// SweepInterval is how often the background sweeper runs.
-var SweepInterval = 10 * time.Second
-
 // ShardN is the number of shards for the servers map.
 // A higher value reduces lock contention but uses more memory.
 const ShardN = 256
 
+// Server is a running xray instance, shared by everything that uses the
+// same proxy URL. It is reference counted: every dialer holds a reference,
+// and so does every connection opened through one, so the instance is
+// closed as soon as the last of them is released. An instance started with
+// StartVmess and the like is pinned instead and runs until Close or
+// Instance.Close.
+//
+// In shared mode (see SetShared) Instance is the core every proxy shares
+// and the server is the outbound tagged tag on it.
 type Server struct {
 	Instance  *core.Instance
 	SocksPort int
-	DrainedAt time.Time // zero = active; non-zero = draining since this time
+
+	tag      string
+	url      string
+	refs     int
+	pinned   bool
+	closed   bool
+	draining bool
+	elem     *list.Element // in the LRU list while cached
+}
+
+// Instance is a proxy started with StartVmess and the like. It embeds the
+// xray core running the proxy; in shared mode that is the core every proxy
+// shares.
+type Instance struct {
+	*core.Instance
+
+	srv *Server
+}
+
+// Close stops the proxy at once, breaking the connections still using it,
+// and removes it from the servers map like CloseImmediately. In shared mode
+// only its outbound is removed and the shared core keeps running.
+func (i *Instance) Close() error {
+	i.srv.closeNow()
+	return nil
+}
+
+// startFunc starts the instance of a proxy URL. The server it returns only
+// has its Instance, SocksPort and tag set.
+type startFunc func() (*Server, error)
+
+// startCall is an instance being started. Callers asking for the same URL
+// meanwhile wait for it and take their reference or pin on the result.
+type startCall struct {
+	done   chan struct{}
+	srv    *Server
+	err    error
+	refs   int
+	pinned bool
 }
 
 var (
-	shardedMu      [ShardN]sync.RWMutex
-	shardedServers [ShardN]map[string]*Server
-	sweeperOnce    sync.Once
-	stopCh         chan struct{}
-	sweeperWG      sync.WaitGroup
+	shardedMu       [ShardN]sync.RWMutex
+	shardedServers  [ShardN]map[string]*Server
+	shardedStarting [ShardN]map[string]*startCall
 )
 
+// startSlots bounds how many instances start at once; see SetStartLimit.
+var startSlots atomic.Pointer[chan struct{}]
+
+func init() {
+	SetStartLimit(runtime.GOMAXPROCS(0))
+}
+
+// SetStartLimit sets how many xray instances may be starting at the same
+// time, so that a burst of new proxies does not start all their cores at
+// once. It defaults to GOMAXPROCS; n < 1 counts as 1. Starts already
+// waiting keep the limit they were queued under.
+func SetStartLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+	slots := make(chan struct{}, n)
+	startSlots.Store(&slots)
+}
+
 func hashShard(proxyURL string) int {
 	// Lightweight non-allocating FNV-1a style hash specialized for strings.
 	var h uint32 = 2166136261
@@ -96,180 +158,308 @@ func hashShard(proxyURL string) int {
 	return int(h % uint32(ShardN))
 }
 
-// StartSweeper launches the background sweeper goroutine if not already running.
-// It is called automatically by the public API; you do not need to call it.
-func startSweeper() {
-	sweeperOnce.Do(func() {
-		ch := make(chan struct{})
-		stopCh = ch
-		sweeperWG.Add(1)
-		go func(stop <-chan struct{}) {
-			sweeper(stop)
-			sweeperWG.Done()
-		}(ch) // pass as parameter so the goroutine uses its own copy
-	})
+// acquireServer returns the server of proxyURL with a reference taken,
+// starting it first if it is not running. Release the reference with
+// release.
+func acquireServer(proxyURL string, start startFunc) (*Server, error) {
+	return retainServer(proxyURL, false, start)
 }
 
-// StopSweeper stops the running sweeper goroutine (if any) and waits for it
-// to exit, then resets its Once gate so a new sweeper can be started.
-// Intended for use in tests only.
-func StopSweeper() {
-	if stopCh != nil {
-		close(stopCh)
+// pinServer returns the server of proxyURL, starting it first if it is not
+// running, and keeps it running until Close.
+func pinServer(proxyURL string, start startFunc) (*Server, error) {
+	return retainServer(proxyURL, true, start)
+}
+
+// retainServer returns the running server of proxyURL or starts it. Only
+// one start per URL is under way at a time: concurrent callers share its
+// outcome instead of starting instances of their own.
+func retainServer(proxyURL string, pin bool, start startFunc) (*Server, error) {
+	idx := hashShard(proxyURL)
+	shardedMu[idx].Lock()
+
+	if srv, ok := shardedServers[idx][proxyURL]; ok {
+		srv.retain(pin)
+		cacheTouch(srv)
+		shardedMu[idx].Unlock()
+		return srv, nil
+	}
+
+	if call, ok := shardedStarting[idx][proxyURL]; ok {
+		call.retain(pin)
+		shardedMu[idx].Unlock()
+
+		<-call.done
+		return call.srv, call.err
+	}
+
+	call := &startCall{done: make(chan struct{})}
+	call.retain(pin)
+	if shardedStarting[idx] == nil {
+		shardedStarting[idx] = make(map[string]*startCall)
+	}
+	shardedStarting[idx][proxyURL] = call
+	shardedMu[idx].Unlock()
+
+	srv, err := startLimited(start)
+
+	shardedMu[idx].Lock()
+	delete(shardedStarting[idx], proxyURL)
+	if err == nil {
+		srv.url = proxyURL
+		srv.refs = call.refs
+		srv.pinned = call.pinned
+		call.srv = srv
+		if shardedServers[idx] == nil {
+			shardedServers[idx] = make(map[string]*Server)
+		}
+		shardedServers[idx][proxyURL] = call.srv
+		cacheAdd(call.srv)
 	}
-	sweeperWG.Wait()
-	// Reset state so a fresh sweeper can be started in the next test.
-	stopCh = nil
-	sweeperOnce = sync.Once{}
+	call.err = err
+	shardedMu[idx].Unlock()
+	close(call.done)
+
+	if err == nil {
+		evictOverflow()
+	}
+	return call.srv, call.err
 }
 
-func sweeper(stop <-chan struct{}) {
-	for {
-		select {
-		case <-stop:
-			return
-		case <-time.After(SweepInterval):
+// startLimited runs start once a start slot is free.
+func startLimited(start startFunc) (*Server, error) {
+	stats.starting.Add(1)
+	defer stats.starting.Add(-1)
+
+	slots := *startSlots.Load()
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	begin := time.Now()
+	srv, err := start()
+	recordStart(time.Since(begin), err)
+
+	return srv, err
+}
+
+// startConfig returns the startFunc of the proxy whose configuration config
+// builds: it adds the proxy's outbound to the shared core in shared mode and
+// starts an instance of its own otherwise. Only a configuration that cannot
+// be built or loaded counts as ErrConfigUnsupported.
+func startConfig(config func() ([]byte, int, error)) startFunc {
+	return func() (*Server, error) {
+		jsonConfig, port, err := config()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", proxyclient.ErrConfigUnsupported, err)
+		}
+
+		cfg, err := core.LoadConfig("json", bytes.NewReader(jsonConfig))
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to load Xray config: %w", proxyclient.ErrConfigUnsupported, err)
 		}
 
-		expired := []struct {
-			url string
-			srv *Server
-		}{}
-		now := time.Now()
-		for idx := range shardedServers {
-			shardedMu[idx].RLock()
-			for url, srv := range shardedServers[idx] {
-				if !srv.DrainedAt.IsZero() && now.Sub(srv.DrainedAt) > DrainTimeout {
-					expired = append(expired, struct {
-						url string
-						srv *Server
-					}{url, srv})
-				}
-			}
-			shardedMu[idx].RUnlock()
+		if sharedMode.Load() {
+			return addOutbound(cfg, port)
 		}
 
-		for _, e := range expired {
-			tryCloseAndDelete(e.url, e.srv)
+		instance, err := core.New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Xray instance: %w", err)
 		}
+		if err := instance.Start(); err != nil {
+			instance.Close() //nolint: errcheck
+			return nil, fmt.Errorf("failed to start Xray instance: %w", err)
+		}
+		return &Server{Instance: instance, SocksPort: port}, nil
 	}
 }
 
-func getServer(proxyURL string) *Server {
-	startSweeper()
-	idx := hashShard(proxyURL)
-	shardedMu[idx].RLock()
-	defer shardedMu[idx].RUnlock()
-
-	if shardedServers[idx] == nil {
-		return nil
+func (c *startCall) retain(pin bool) {
+	if pin {
+		c.pinned = true
+	} else {
+		c.refs++
 	}
-	if proxy, ok := shardedServers[idx][proxyURL]; ok {
-		// If server is draining, don't reuse it — return nil and let caller create fresh.
-		// This prevents the "revive" bug where getServer() resets DrainedAt, blocking sweeper.
-		if !proxy.DrainedAt.IsZero() {
-			return nil
-		}
-		return proxy
+}
+
+// retain must be called with the shard locked.
+func (s *Server) retain(pin bool) {
+	if pin {
+		s.pinned = true
+	} else {
+		s.refs++
 	}
-	return nil
 }
 
-func setServer(proxyURL string, instance *core.Instance, port int) {
-	startSweeper()
-	idx := hashShard(proxyURL)
+// use takes a reference for a connection, unless the server is closed.
+func (s *Server) use() bool {
+	idx := hashShard(s.url)
 	shardedMu[idx].Lock()
 	defer shardedMu[idx].Unlock()
 
-	if shardedServers[idx] == nil {
-		shardedServers[idx] = make(map[string]*Server)
+	if s.closed {
+		return false
+	}
+	s.refs++
+	cacheTouch(s)
+	return true
+}
+
+// release drops a reference and closes the server if it was the last one.
+// A pinned server left idle may be evicted now if the cache is over its
+// limit.
+func (s *Server) release() {
+	idx := hashShard(s.url)
+	shardedMu[idx].Lock()
+	s.refs--
+	last := s.unused()
+	idle := s.refs == 0 && s.elem != nil
+	shardedMu[idx].Unlock()
+
+	if last {
+		s.close()
+	}
+	if idle {
+		evictOverflow()
+	}
+}
+
+// unused marks the server closed and removes it from the map if nothing
+// holds it any more. It must be called with the shard locked.
+func (s *Server) unused() bool {
+	if s.closed || s.refs > 0 || s.pinned {
+		return false
+	}
+
+	s.markClosed()
+	s.detach()
+	return true
+}
+
+// retire removes the server from the map and unpins it, so that it closes
+// once unused. It must be called with the shard locked and reports
+// whether the caller has to close the instance now.
+func (s *Server) retire() bool {
+	s.detach()
+	s.pinned = false
+	return s.unused()
+}
+
+// detach removes the server from the map and the LRU list; if it is still
+// in use it counts as draining until it closes. It must be called with the
+// shard locked.
+func (s *Server) detach() {
+	idx := hashShard(s.url)
+	if shardedServers[idx][s.url] == s {
+		delete(shardedServers[idx], s.url)
+	}
+	cacheRemove(s)
+
+	if !s.closed && !s.draining {
+		s.draining = true
+		stats.draining.Add(1)
 	}
-	shardedServers[idx][proxyURL] = &Server{
-		Instance:  instance,
-		SocksPort: port,
-		DrainedAt: time.Time{},
+}
+
+// markClosed must be called with the shard locked. It reports false if the
+// server was closed already.
+func (s *Server) markClosed() bool {
+	if s.closed {
+		return false
+	}
+
+	s.closed = true
+	if s.draining {
+		s.draining = false
+		stats.draining.Add(-1)
 	}
+	return true
 }
 
-// tryCloseAndDelete checks the entry under lock, closes it if still draining,
-// then removes it from the map. The lock pattern ensures:
-//   - The entry hasn't been revived (DrainedAt reset to zero) since collection.
-//   - The entry hasn't been replaced by a newer server for the same URL.
-func tryCloseAndDelete(url string, srv *Server) {
-	idx := hashShard(url)
+// closeNow closes the server even if it is still in use.
+func (s *Server) closeNow() {
+	idx := hashShard(s.url)
 	shardedMu[idx].Lock()
-	defer shardedMu[idx].Unlock()
-	if srv == nil || shardedServers[idx][url] != srv || srv.DrainedAt.IsZero() {
-		return
+	first := s.markClosed()
+	s.detach()
+	shardedMu[idx].Unlock()
+
+	if first {
+		s.close()
 	}
-	if srv.Instance != nil {
-		srv.Instance.Close() //nolint: errcheck
+}
+
+func (s *Server) close() {
+	if s.tag != "" {
+		removeOutbound(s)
+		return
 	}
-	if shardedServers[idx][url] == srv {
-		delete(shardedServers[idx], url)
+	if s.Instance != nil {
+		s.Instance.Close() //nolint: errcheck
 	}
 }
 
-// Close synchronously closes the xray instance and removes it from the servers
-// map. This prevents goroutine and memory leaks when testing high volumes of
-// proxies where the previous delayed-close behavior caused resource accumulation.
+// Close unpins the instance of proxyURL and removes it from the servers
+// map, so the next user starts a fresh one. The instance itself is closed
+// once the dialers and connections still using it are released, right away
+// if there are none.
 func Close(proxyURL string) {
 	idx := hashShard(proxyURL)
 	shardedMu[idx].Lock()
-	defer shardedMu[idx].Unlock()
 
 	srv, ok := shardedServers[idx][proxyURL]
 	if !ok {
+		shardedMu[idx].Unlock()
 		return
 	}
-	if srv.Instance != nil {
-		srv.Instance.Close() //nolint: errcheck
+	last := srv.retire()
+	shardedMu[idx].Unlock()
+
+	if last {
+		srv.close()
 	}
-	delete(shardedServers[idx], proxyURL)
 }
 
-// CloseImmediately synchronously closes the xray instance and removes it from
-// the servers map. Use this when you need immediate cleanup and are certain no
-// other goroutines are using the instance.
+// CloseImmediately closes the instance of proxyURL and removes it from the
+// servers map, breaking the connections still using it. Use Close unless
+// the instance must go at once.
 func CloseImmediately(proxyURL string) {
 	idx := hashShard(proxyURL)
 	shardedMu[idx].Lock()
-	defer shardedMu[idx].Unlock()
-
 	srv, ok := shardedServers[idx][proxyURL]
-	if !ok {
-		return
-	}
-	if srv.Instance != nil {
-		srv.Instance.Close() //nolint: errcheck
+	shardedMu[idx].Unlock()
+
+	if ok {
+		srv.closeNow()
 	}
-	delete(shardedServers[idx], proxyURL)
 }
 
-// CloseAll marks all servers as draining immediately. The sweeper will close
-// each one after DrainTimeout.
+// CloseAll calls Close for every instance.
 func CloseAll() {
-	startSweeper()
-	now := time.Now()
+	var urls []string
 	for idx := range shardedServers {
-		shardedMu[idx].Lock()
-		for _, srv := range shardedServers[idx] {
-			if srv.DrainedAt.IsZero() {
-				srv.DrainedAt = now
-			}
+		shardedMu[idx].RLock()
+		for url := range shardedServers[idx] {
+			urls = append(urls, url)
 		}
-		shardedMu[idx].Unlock()
+		shardedMu[idx].RUnlock()
+	}
+
+	for _, url := range urls {
+		Close(url)
 	}
 }
 
-// ResetForTest clears all entries from the servers map and resets the sweeper,
-// so tests get a clean state without reassigning the map variable (which would
-// race with any goroutines still iterating over the old map). Safe to call from tests.
+// ResetForTest clears all entries from the servers map, so tests get a
+// clean state without reassigning the map variable (which would race with
+// any goroutines still iterating over the old map). Safe to call from tests.
 func ResetForTest() {
 	for idx := range shardedServers {
 		shardedMu[idx].Lock()
 		shardedServers[idx] = nil
+		shardedStarting[idx] = nil
 		shardedMu[idx].Unlock()
 	}
-	StopSweeper()
+	resetCache()
+	resetShared()
 }
@@ -1,10 +1,24 @@
 package xray
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/cnlangzi/proxyclient"
+	"github.com/xtls/xray-core/features/outbound"
 )
 
 func TestMain(m *testing.M) {
@@ -47,20 +61,6 @@ func getFromShard(url string) *Server {
 	return shardedServers[idx][url]
 }
 
-// reviveServer resets DrainedAt so the server is considered active again,
-// used in tests to simulate a server coming back to life.
-func reviveServer(url string) {
-	idx := hashShard(url)
-	shardedMu[idx].Lock()
-	defer shardedMu[idx].Unlock()
-	if shardedServers[idx] == nil {
-		return
-	}
-	if srv, ok := shardedServers[idx][url]; ok {
-		srv.DrainedAt = time.Time{}
-	}
-}
-
 // existsInShard checks if a URL exists in the sharded map.
 func existsInShard(url string) bool {
 	return getFromShard(url) != nil
@@ -77,208 +77,653 @@ func countAllServers() int {
 	return n
 }
 
-func TestSetAndGet(t *testing.T) {
+// fakeStart returns a startFunc that counts its calls. The instance is
+// nil, which the servers map closes as a no-op.
+func fakeStart(calls *atomic.Int64) startFunc {
+	return func() (*Server, error) {
+		calls.Add(1)
+		return &Server{SocksPort: 1080}, nil
+	}
+}
+
+func isClosed(srv *Server) bool {
+	idx := hashShard(srv.url)
+	shardedMu[idx].RLock()
+	defer shardedMu[idx].RUnlock()
+	return srv.closed
+}
+
+func TestAcquireShares(t *testing.T) {
 	ResetForTest()
-	DrainTimeout = 50 * time.Millisecond
-	SweepInterval = 10 * time.Millisecond
+	var calls atomic.Int64
 
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Time{}})
+	a, err := acquireServer("vmess://a", fakeStart(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := acquireServer("vmess://a", fakeStart(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	srv := getServer("socks5://127.0.0.1:1080")
-	if srv == nil {
-		t.Fatal("expected server, got nil")
+	if a != b {
+		t.Error("expected both dialers to share one server")
 	}
-	if srv.SocksPort != 1080 {
-		t.Errorf("expected port 1080, got %d", srv.SocksPort)
+	if calls.Load() != 1 {
+		t.Errorf("expected one start, got %d", calls.Load())
+	}
+	if a.SocksPort != 1080 {
+		t.Errorf("expected port 1080, got %d", a.SocksPort)
+	}
+
+	a.release()
+	if isClosed(a) || !existsInShard("vmess://a") {
+		t.Error("expected server to stay while a reference is held")
+	}
+
+	b.release()
+	if !isClosed(a) || existsInShard("vmess://a") {
+		t.Error("expected server to be closed after the last release")
 	}
+
+	// the next user starts a fresh instance
+	c, _ := acquireServer("vmess://a", fakeStart(&calls))
+	if c == a || calls.Load() != 2 {
+		t.Error("expected a fresh server after the old one was closed")
+	}
+	c.release()
 }
 
-func TestGetNonExistent(t *testing.T) {
+func TestStartError(t *testing.T) {
 	ResetForTest()
-	srv := getServer("socks5://127.0.0.1:9999")
-	if srv != nil {
-		t.Error("expected nil for non-existent server")
+
+	_, err := acquireServer("vmess://a", func() (*Server, error) {
+		return nil, errors.New("boom")
+	})
+	if err == nil {
+		t.Fatal("expected start error")
+	}
+	if existsInShard("vmess://a") {
+		t.Error("expected no server after a failed start")
 	}
 }
 
-func TestCloseRemovesServer(t *testing.T) {
+func TestConnectionsHoldServer(t *testing.T) {
 	ResetForTest()
+	var calls atomic.Int64
+
+	srv, _ := acquireServer("vmess://a", fakeStart(&calls))
+	d := newDialer(srv, nil)
+
+	// stand in for a connection opened through the dialer
+	if !d.acquire() {
+		t.Fatal("expected acquire to succeed on an open dialer")
+	}
+	client, server := net.Pipe()
+	defer server.Close() //nolint: errcheck
+	conn := &serverConn{Conn: client, srv: srv}
+
+	d.Close() //nolint: errcheck
+	d.Close() //nolint: errcheck
+	if isClosed(srv) {
+		t.Error("expected server to stay while a connection is open")
+	}
+
+	if _, err := d.DialContext(context.Background(), "tcp", "example.com:80"); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected net.ErrClosed after Close, got %v", err)
+	}
+
+	conn.Close() //nolint: errcheck
+	conn.Close() //nolint: errcheck
+	if !isClosed(srv) {
+		t.Error("expected server to be closed with its last connection")
+	}
+}
 
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Time{}})
+func TestPinnedServer(t *testing.T) {
+	ResetForTest()
+	var calls atomic.Int64
 
-	Close("socks5://127.0.0.1:1080")
+	pinned, _ := pinServer("vmess://a", fakeStart(&calls))
+	srv, _ := acquireServer("vmess://a", fakeStart(&calls))
+	if srv != pinned || calls.Load() != 1 {
+		t.Fatal("expected the dialer to share the pinned server")
+	}
 
-	if existsInShard("socks5://127.0.0.1:1080") {
-		t.Error("expected server to be removed from map after Close()")
+	srv.release()
+	if isClosed(srv) {
+		t.Error("expected pinned server to survive its dialers")
 	}
 
-	got := getServer("socks5://127.0.0.1:1080")
-	if got != nil {
-		t.Fatal("expected nil after getServer on removed server")
+	Close("vmess://a")
+	if !isClosed(srv) || existsInShard("vmess://a") {
+		t.Error("expected Close to stop an unused pinned server")
+	}
+}
+
+func TestCloseWaitsForUsers(t *testing.T) {
+	ResetForTest()
+	var calls atomic.Int64
+
+	srv, _ := acquireServer("vmess://a", fakeStart(&calls))
+
+	Close("vmess://a")
+	if existsInShard("vmess://a") {
+		t.Error("expected Close to remove the server from the map")
+	}
+	if isClosed(srv) {
+		t.Error("expected Close to leave a server in use running")
+	}
+
+	srv.release()
+	if !isClosed(srv) {
+		t.Error("expected server to be closed after its last user")
 	}
 }
 
 func TestCloseIdempotent(t *testing.T) {
 	ResetForTest()
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Time{}})
+	injectServer("vmess://a", &Server{SocksPort: 1080, url: "vmess://a"})
 
-	Close("socks5://127.0.0.1:1080")
-	Close("socks5://127.0.0.1:1080") // second call must not panic
+	Close("vmess://a")
+	Close("vmess://a") // second call must not panic
 
-	if existsInShard("socks5://127.0.0.1:1080") {
+	if existsInShard("vmess://a") {
 		t.Error("expected server to be removed from map")
 	}
 }
 
 func TestCloseNonExistent(t *testing.T) {
 	ResetForTest()
-	Close("socks5://127.0.0.1:9999")
+	Close("vmess://missing")
+	CloseImmediately("vmess://missing")
+}
+
+func TestCloseImmediately(t *testing.T) {
+	ResetForTest()
+	var calls atomic.Int64
+
+	srv, _ := acquireServer("vmess://a", fakeStart(&calls))
+
+	CloseImmediately("vmess://a")
+	if !isClosed(srv) || existsInShard("vmess://a") {
+		t.Error("expected CloseImmediately to close a server in use")
+	}
+
+	// late releases must not close it twice
+	srv.release()
+	if srv.use() {
+		t.Error("expected a closed server to refuse new connections")
+	}
 }
 
 func TestCloseAll(t *testing.T) {
 	ResetForTest()
-	DrainTimeout = 50 * time.Millisecond
-	SweepInterval = 10 * time.Millisecond
+	var calls atomic.Int64
 
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Time{}})
-	injectServer("socks5://127.0.0.1:1081", &Server{SocksPort: 1081, DrainedAt: time.Time{}})
-	injectServer("socks5://127.0.0.1:1082", &Server{SocksPort: 1082, DrainedAt: time.Time{}})
+	var servers []*Server
+	for _, port := range []int{1080, 1081, 1082} {
+		srv, _ := pinServer("vmess://a:"+itoa(port), fakeStart(&calls))
+		servers = append(servers, srv)
+	}
+	inUse, _ := acquireServer("vmess://b", fakeStart(&calls))
 
 	CloseAll()
 
-	for _, port := range []int{1080, 1081, 1082} {
-		key := "socks5://127.0.0.1:" + itoa(port)
-		srv := getFromShard(key)
-		if srv == nil || srv.DrainedAt.IsZero() {
-			t.Errorf("expected server %s to be draining after CloseAll", key)
+	if n := countAllServers(); n > 0 {
+		t.Errorf("expected no servers after CloseAll, found %d", n)
+	}
+	for _, srv := range servers {
+		if !isClosed(srv) {
+			t.Errorf("expected %s to be closed", srv.url)
+		}
+	}
+	if isClosed(inUse) {
+		t.Error("expected CloseAll to leave a server in use running")
+	}
+	inUse.release()
+}
+
+func TestConcurrentAcquireRelease(t *testing.T) {
+	ResetForTest()
+	var calls atomic.Int64
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			url := "vmess://a:" + itoa(1000+idx%10)
+			srv, err := acquireServer(url, fakeStart(&calls))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if srv.use() {
+				srv.release()
+			}
+			if idx%5 == 0 {
+				Close(url)
+			}
+			srv.release()
+		}(i)
+	}
+	wg.Wait()
+
+	if n := countAllServers(); n > 0 {
+		t.Errorf("expected no servers after every reference was released, found %d", n)
+	}
+}
+
+func TestSingleFlightStart(t *testing.T) {
+	ResetForTest()
+	var calls atomic.Int64
+	release := make(chan struct{})
+
+	start := func() (*Server, error) {
+		calls.Add(1)
+		<-release
+		return &Server{SocksPort: 1080}, nil
+	}
+
+	const n = 50
+	servers := make(chan *Server, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv, err := acquireServer("vmess://a", start)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			servers <- srv
+		}()
+	}
+
+	// let every caller join the start before it finishes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(servers)
+
+	if calls.Load() != 1 {
+		t.Errorf("expected one start for concurrent callers, got %d", calls.Load())
+	}
+
+	var first *Server
+	for srv := range servers {
+		if first == nil {
+			first = srv
+		} else if srv != first {
+			t.Fatal("expected every caller to get the same server")
 		}
 	}
+	for i := 0; i < n; i++ {
+		first.release()
+	}
+	if !isClosed(first) || countAllServers() > 0 {
+		t.Error("expected the server to close after every caller released it")
+	}
 }
 
-func TestSweeperRemovesExpired(t *testing.T) {
+func TestSingleFlightError(t *testing.T) {
 	ResetForTest()
-	DrainTimeout = 80 * time.Millisecond
-	SweepInterval = 15 * time.Millisecond
+	var calls atomic.Int64
+	release := make(chan struct{})
 
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Now().Add(-200 * time.Millisecond)})
+	start := func() (*Server, error) {
+		calls.Add(1)
+		<-release
+		return nil, errors.New("boom")
+	}
 
-	getServer("socks5://127.0.0.1:9998")
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := acquireServer("vmess://a", start); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
 
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Now().Add(-200 * time.Millisecond)})
+	if calls.Load() != 1 || failed.Load() != 10 {
+		t.Errorf("expected one failed start shared by 10 callers, got %d starts and %d failures", calls.Load(), failed.Load())
+	}
 
-	time.Sleep(300 * time.Millisecond)
+	// a failed start is not cached
+	srv, err := acquireServer("vmess://a", fakeStart(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.release()
+}
 
-	if existsInShard("socks5://127.0.0.1:1080") {
-		t.Error("expected server to be removed by sweeper after DrainTimeout")
+func TestStartLimit(t *testing.T) {
+	ResetForTest()
+	SetStartLimit(2)
+	defer SetStartLimit(runtime.GOMAXPROCS(0))
+
+	var running, peak atomic.Int64
+	start := func() (*Server, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		return &Server{SocksPort: 1080}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			srv, err := acquireServer("vmess://a:"+itoa(idx), start)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			srv.release()
+		}(i)
+	}
+	wg.Wait()
+
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 concurrent starts, got %d", peak.Load())
 	}
 }
 
-func TestSweeperSkipsRevivedEntry(t *testing.T) {
+func TestMaxInstancesEvictsLRU(t *testing.T) {
 	ResetForTest()
-	DrainTimeout = 50 * time.Millisecond
-	SweepInterval = 10 * time.Millisecond
+	SetMaxInstances(2)
+	defer SetMaxInstances(0)
+	var calls atomic.Int64
 
-	// Server is already draining when inserted.
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Now().Add(-100 * time.Millisecond)})
+	a, _ := pinServer("vmess://a", fakeStart(&calls))
+	b, _ := pinServer("vmess://b", fakeStart(&calls))
 
-	// getServer on a draining server returns nil and does NOT revive it.
-	result := getServer("socks5://127.0.0.1:1080")
-	if result != nil {
-		t.Error("expected getServer to return nil for draining server")
+	// using a makes b the least recently used
+	if _, err := pinServer("vmess://a", fakeStart(&calls)); err != nil {
+		t.Fatal(err)
 	}
+	c, _ := pinServer("vmess://c", fakeStart(&calls))
 
-	// Wait for sweeper to run and close the draining entry.
-	time.Sleep(200 * time.Millisecond)
+	if !isClosed(b) || existsInShard("vmess://b") {
+		t.Error("expected b to be evicted")
+	}
+	if isClosed(a) || isClosed(c) {
+		t.Error("expected a and c to stay running")
+	}
 
-	// Server should be gone — sweeper closed it because it was still draining.
-	if existsInShard("socks5://127.0.0.1:1080") {
-		t.Error("expected server to be removed by sweeper after DrainTimeout")
+	s := Stats()
+	if s.Live != 2 || s.Evictions != 1 || s.Draining != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	SetMaxInstances(1)
+	if !isClosed(a) || isClosed(c) {
+		t.Error("expected lowering the limit to evict a")
 	}
 }
 
-func TestSweeperSkipsActiveEntry(t *testing.T) {
+func TestInUseServerNotEvicted(t *testing.T) {
 	ResetForTest()
-	DrainTimeout = 50 * time.Millisecond
-	SweepInterval = 10 * time.Millisecond
+	SetMaxInstances(1)
+	defer SetMaxInstances(0)
+	var calls atomic.Int64
+
+	a, err := pinServer("vmess://a", fakeStart(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireServer("vmess://a", fakeStart(&calls)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := acquireServer("vmess://b", fakeStart(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Time{}})
+	if isClosed(a) || !existsInShard("vmess://a") {
+		t.Error("expected the server in use to stay cached")
+	}
+	if s := Stats(); s.Live != 2 || s.Draining != 0 || s.Evictions != 0 {
+		t.Errorf("expected 2 live and none evicted, got %+v", s)
+	}
 
-	time.Sleep(200 * time.Millisecond)
+	// a user of a's URL shares its core rather than starting another
+	if _, err := acquireServer("vmess://a", fakeStart(&calls)); err != nil {
+		t.Fatal(err)
+	}
+	a.release()
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 starts, got %d", calls.Load())
+	}
 
-	if !existsInShard("socks5://127.0.0.1:1080") {
-		t.Error("expected active server to NOT be removed")
+	// once idle, a is the least recently used and goes
+	a.release()
+	if !isClosed(a) || existsInShard("vmess://a") {
+		t.Error("expected a to be evicted once idle")
+	}
+	if s := Stats(); s.Live != 1 || s.Draining != 0 || s.Evictions != 1 {
+		t.Errorf("expected 1 live and 1 evicted, got %+v", s)
+	}
+
+	b.release()
+	if s := Stats(); s.Live != 0 || s.Draining != 0 {
+		t.Errorf("expected no instances, got %+v", s)
 	}
 }
 
-func TestTryCloseAndDelete_NotInMap(t *testing.T) {
+func TestMaxInstancesAllPinned(t *testing.T) {
 	ResetForTest()
-	tryCloseAndDelete("socks5://127.0.0.1:9999", nil)
+	SetMaxInstances(2)
+	defer SetMaxInstances(0)
+	var calls atomic.Int64
+
+	var servers []*Server
+	for _, u := range []string{"vmess://a", "vmess://b", "vmess://c", "vmess://d", "vmess://e"} {
+		srv, err := pinServer(u, fakeStart(&calls))
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, srv)
+	}
+
+	running := 0
+	for _, srv := range servers {
+		if !isClosed(srv) {
+			running++
+		}
+	}
+	if running != 2 {
+		t.Errorf("expected 2 running instances, got %d", running)
+	}
+	if s := Stats(); s.Live != 2 || s.Draining != 0 || s.Evictions != 3 {
+		t.Errorf("unexpected stats %+v", s)
+	}
 }
 
-func TestTryCloseAndDelete_WrongPointer(t *testing.T) {
+func TestStatsStarts(t *testing.T) {
 	ResetForTest()
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: time.Now()})
 
-	ghost := &Server{SocksPort: 9999, DrainedAt: time.Now()}
-	tryCloseAndDelete("socks5://127.0.0.1:1080", ghost)
+	slow := func() (*Server, error) {
+		time.Sleep(10 * time.Millisecond)
+		return &Server{SocksPort: 1080}, nil
+	}
+	failed := func() (*Server, error) {
+		return nil, errors.New("boom")
+	}
+
+	a, err := acquireServer("vmess://a", slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.release()
+	if _, err := acquireServer("vmess://b", failed); err == nil {
+		t.Fatal("expected start error")
+	}
+
+	s := Stats()
+	if s.Starts != 1 || s.StartFailures != 1 || s.Starting != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if s.AvgStartLatency < 10*time.Millisecond || s.MaxStartLatency < s.AvgStartLatency || s.LastStartLatency != s.MaxStartLatency {
+		t.Errorf("unexpected start latency %+v", s)
+	}
 
-	if !existsInShard("socks5://127.0.0.1:1080") {
-		t.Error("expected server to remain when wrong pointer is passed")
+	CloseImmediately("vmess://a")
+	if s := Stats(); s.Live != 0 || s.Draining != 0 {
+		t.Errorf("expected no instances, got %+v", s)
 	}
 }
 
-func TestTryCloseAndDelete_RevivedEntry(t *testing.T) {
+// directConfig is a configuration whose first outbound connects directly.
+func directConfig() ([]byte, int, error) {
+	b, err := json.Marshal(&XRayConfig{
+		Outbounds: []Outbound{
+			{Tag: "direct", Protocol: "freedom", Settings: map[string]interface{}{}},
+		},
+	})
+	return b, 0, err
+}
+
+func TestSharedCore(t *testing.T) {
 	ResetForTest()
-	now := time.Now()
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: now})
+	SetShared(true)
+	defer SetShared(false)
 
-	reviveServer("socks5://127.0.0.1:1080")
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok")) //nolint: errcheck
+	}))
+	defer web.Close()
+
+	a, err := acquireServer("vmess://a", startConfig(directConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := acquireServer("vmess://b", startConfig(directConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Instance == nil || a.Instance != b.Instance {
+		t.Fatal("expected both proxies on the shared core")
+	}
+	if a.tag == "" || a.tag == b.tag {
+		t.Fatalf("expected distinct outbound tags, got %q and %q", a.tag, b.tag)
+	}
+	if s := Stats(); s.Live != 2 || s.Outbounds != 2 || s.MemoryEstimate != InstanceMemory+2*OutboundMemory {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// the default outbound of the shared core is a blackhole, so the
+	// request only gets through when routed to the proxy's outbound
+	d := newDialer(a, nil)
+	conn, err := d.DialContext(context.Background(), "tcp", web.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n")) //nolint: errcheck
+	resp, _ := io.ReadAll(conn)
+	if !strings.HasSuffix(string(resp), "\r\n\r\nok") {
+		t.Errorf("expected ok, got %q", resp)
+	}
 
-	tryCloseAndDelete("socks5://127.0.0.1:1080", &Server{SocksPort: 1080, DrainedAt: now})
+	om := a.Instance.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	conn.Close() //nolint: errcheck
+	d.Close()    //nolint: errcheck
+	if om.GetHandler(a.tag) != nil {
+		t.Error("expected the outbound to be removed with its last user")
+	}
+	if om.GetHandler(b.tag) == nil {
+		t.Error("expected the other outbound to stay")
+	}
 
-	if !existsInShard("socks5://127.0.0.1:1080") {
-		t.Error("expected server to remain after tryCloseAndDelete on revived entry")
+	b.release()
+	if n, running := sharedOutbounds(); n != 0 || running {
+		t.Errorf("expected the shared core to stop, got %d outbounds, running %v", n, running)
 	}
 }
 
-func TestTryCloseAndDelete_ReplacedEntry(t *testing.T) {
+func TestSharedStartClose(t *testing.T) {
 	ResetForTest()
-	old := &Server{SocksPort: 1080, DrainedAt: time.Now()}
-	injectServer("socks5://127.0.0.1:1080", old)
+	SetShared(true)
+	defer SetShared(false)
 
-	injectServer("socks5://127.0.0.1:1080", &Server{SocksPort: 9999, DrainedAt: time.Time{}})
+	a, err := url.Parse("trojan://secret@a.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := url.Parse("trojan://secret@b.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	tryCloseAndDelete("socks5://127.0.0.1:1080", old)
+	instA, port, err := StartTrojan(a, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 0 {
+		t.Errorf("expected no local port in shared mode, got %d", port)
+	}
+	instB, _, err := StartTrojan(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instA.Instance != instB.Instance {
+		t.Fatal("expected both proxies on the shared core")
+	}
 
-	srv := getFromShard("socks5://127.0.0.1:1080")
-	if srv == nil {
-		t.Fatal("expected server to still exist")
+	// closing one proxy must not take the others down with the core
+	instA.Close() //nolint: errcheck
+	om := instB.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if om.GetHandler(instA.srv.tag) != nil {
+		t.Error("expected the closed proxy's outbound to be removed")
+	}
+	if om.GetHandler(instB.srv.tag) == nil {
+		t.Error("expected the other outbound to stay")
+	}
+	if n, running := sharedOutbounds(); n != 1 || !running {
+		t.Errorf("expected the shared core to keep running, got %d outbounds, running %v", n, running)
 	}
-	if srv.SocksPort != 9999 {
-		t.Errorf("expected new server port 9999, got %d", srv.SocksPort)
+
+	instB.Close() //nolint: errcheck
+	if n, running := sharedOutbounds(); n != 0 || running {
+		t.Errorf("expected the shared core to stop, got %d outbounds, running %v", n, running)
 	}
 }
 
-func TestConcurrentGetSetClose(t *testing.T) {
+func TestStartErrorKinds(t *testing.T) {
 	ResetForTest()
-	DrainTimeout = 50 * time.Millisecond
-	SweepInterval = 10 * time.Millisecond
 
-	var wg sync.WaitGroup
-	for i := 0; i < 50; i++ {
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			url := "socks5://127.0.0.1:" + itoa(1000+idx%10)
-			injectServer(url, &Server{SocksPort: 1000+idx%10, DrainedAt: time.Time{}})
-			_ = getServer(url)
-			Close(url)
-			_ = getServer(url)
-		}(i)
+	_, err := acquireServer("vmess://bad", startConfig(func() ([]byte, int, error) {
+		return nil, 0, errors.New("bad url")
+	}))
+	if !errors.Is(err, proxyclient.ErrConfigUnsupported) {
+		t.Errorf("expected ErrConfigUnsupported for a bad url, got %v", err)
 	}
-	wg.Wait()
 
-	if n := countAllServers(); n > 0 {
-		t.Errorf("expected no servers after concurrent get/set/close, found %d", n)
+	_, err = acquireServer("vmess://garbled", startConfig(func() ([]byte, int, error) {
+		return []byte("{"), 0, nil
+	}))
+	if !errors.Is(err, proxyclient.ErrConfigUnsupported) {
+		t.Errorf("expected ErrConfigUnsupported for a garbled config, got %v", err)
+	}
+
+	_, err = dialContext(context.Background(), nil, "tcp", "no-port")
+	if !errors.Is(err, proxyclient.ErrConfigUnsupported) {
+		t.Errorf("expected ErrConfigUnsupported for a bad address, got %v", err)
 	}
 }